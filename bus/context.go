@@ -1,8 +1,18 @@
 package bus
 
-import "github.com/kataras/iris/v12"
+import (
+	"time"
+
+	"github.com/kataras/iris/v12"
+)
 
 type ContextHttp struct {
 	iris.Context
 	RelativePath string
 }
+
+type ContextTimer struct {
+	Time time.Time // when this run starts
+	Last time.Time // when the last run started, zero if it's the first
+	Next time.Time // when the next run is scheduled
+}
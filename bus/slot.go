@@ -8,6 +8,13 @@ const (
 	SlotHttp SlotType = iota
 	SlotRedis
 	SlotKafka
+	SlotTimer
+)
+
+// what to do when a timer fires while the last run is still running
+const (
+	OverlapSkip  = "skip"
+	OverlapQueue = "queue"
 )
 
 type Slot interface {
@@ -8,7 +8,11 @@ import (
 	"github.com/jumboframes/tigerbalm/bus"
 	"github.com/jumboframes/tigerbalm/frame"
 	"github.com/jumboframes/tigerbalm/frame/capal/tblog"
+	"github.com/jumboframes/tigerbalm/frame/capal/tbredis"
+	"github.com/jumboframes/tigerbalm/server/admin"
 	"github.com/jumboframes/tigerbalm/server/kafka"
+	"github.com/jumboframes/tigerbalm/server/redis"
+	"github.com/jumboframes/tigerbalm/server/timer"
 	"github.com/jumboframes/tigerbalm/server/web"
 )
 
@@ -49,6 +53,24 @@ func main() {
 		bus.AddSlot(consumer)
 	}
 
+	// redis
+	if tigerbalm.Conf.Redis.Enable {
+		r, err := tbredis.NewRedisFromConf()
+		if err != nil {
+			tblog.Errorf("main | new redis err: %s", err)
+			return
+		}
+		defer r.Fini()
+		subscriber := redis.NewSubscriber(r)
+		defer subscriber.Fini()
+		bus.AddSlot(subscriber)
+	}
+
+	// timer
+	timer := timer.NewTimer()
+	defer timer.Fini()
+	bus.AddSlot(timer)
+
 	// frame
 	frame, err := frame.NewFrame(bus)
 	if err != nil {
@@ -57,6 +79,26 @@ func main() {
 	}
 	defer frame.Fini()
 
+	// admin
+	if tigerbalm.Conf.Admin.Enable {
+		admin, err := admin.NewAdmin()
+		if err != nil {
+			tblog.Errorf("main | new admin err: %s", err)
+			return
+		}
+		defer admin.Fini()
+		admin.Handle("/pools", func() interface{} {
+			return frame.PoolStats()
+		})
+		admin.Handle("/conflicts", func() interface{} {
+			return frame.Conflicts()
+		})
+		admin.Handle("/schedules", func() interface{} {
+			return timer.Schedules()
+		})
+		go admin.Serve(ctx)
+	}
+
 	// signal
 	sig := tigerbalm.NewSignal(tigerbalm.OptionSignalCancel(cancel))
 	sig.Add(syscall.SIGHUP, frame)
@@ -26,9 +26,38 @@ type Config struct {
 		Addr string `yaml:"addr"`
 	} `yaml:"web"`
 
+	Admin struct {
+		Enable bool   `yaml:"enable"`
+		Addr   string `yaml:"addr"`
+	} `yaml:"admin"`
+
 	Kafka struct {
-		Enable   bool     `yaml:"enable"`
-		Brokers  []string `yaml:"brokers"`
+		Enable  bool     `yaml:"enable"`
+		Brokers []string `yaml:"brokers"`
+		Version string   `yaml:"version"` // like 0.10.2.1 or 2.8.0
+		Sasl    struct {
+			Enable    bool   `yaml:"enable"`
+			Mechanism string `yaml:"mechanism"` // plain, scram-sha-256 or scram-sha-512
+			User      string `yaml:"user"`
+			Password  string `yaml:"password"`
+		} `yaml:"sasl"`
+		TLS struct {
+			Enable             bool   `yaml:"enable"`
+			CA                 string `yaml:"ca"`
+			Cert               string `yaml:"cert"`
+			Key                string `yaml:"key"`
+			InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+		} `yaml:"tls"`
+		Producer struct {
+			Compression string `yaml:"compression"` // none, gzip, snappy, lz4 or zstd
+			Acks        string `yaml:"acks"`        // none, leader or all
+			Flush       struct {
+				Bytes       int           `yaml:"bytes"`
+				Messages    int           `yaml:"messages"`
+				Frequency   time.Duration `yaml:"frequency"` // milliseconds
+				MaxMessages int           `yaml:"max_messages"`
+			} `yaml:"flush"`
+		} `yaml:"producer"`
 		Consumer struct { //dumped from sarama
 			Group struct {
 				Session struct {
@@ -44,10 +73,38 @@ type Config struct {
 		} `yaml:"consumer"`
 	} `yaml:"kafka"`
 
+	Redis struct {
+		Enable   bool   `yaml:"enable"`
+		Addr     string `yaml:"addr"`
+		User     string `yaml:"user"`
+		Password string `yaml:"password"`
+		DB       int    `yaml:"db"`
+		Pool     struct {
+			Size    int `yaml:"size"`
+			MinIdle int `yaml:"min_idle"`
+		} `yaml:"pool"`
+		Timeout struct {
+			Dial  time.Duration `yaml:"dial"`
+			Read  time.Duration `yaml:"read"`
+			Write time.Duration `yaml:"write"`
+		} `yaml:"timeout"`
+	} `yaml:"redis"`
+
 	Plugin struct {
-		Path      string `yaml:"path"`
-		WatchPath bool   `yaml:"watch_path"`
-		Log       struct {
+		Path      string        `yaml:"path"`
+		WatchPath bool          `yaml:"watch_path"`
+		Timeout   time.Duration `yaml:"timeout"` // default timeout of handlers
+		// max seconds of a streamed response, default 600
+		StreamTimeout time.Duration `yaml:"stream_timeout"`
+		Engine        string        `yaml:"engine"` // otto or goja, default otto
+		// engines of plugins by name, override the global engine
+		Engines map[string]string `yaml:"engines"`
+		Pool    struct {
+			MinIdle     *int          `yaml:"min_idle"` // default 1 if not set
+			MaxSize     int           `yaml:"max_size"`
+			WaitTimeout time.Duration `yaml:"wait_timeout"`
+		} `yaml:"pool"`
+		Log struct {
 			Enable   bool   `yaml:"enable"`
 			Path     string `yaml:"path"`
 			Level    string `yaml:"level"`
@@ -12,8 +12,24 @@ import (
 )
 
 var (
-	ErrRegisterNotFunction = errors.New("register not function")
-	ErrRegisterNotObject   = errors.New("register not object")
-	ErrNewInterpreter      = errors.New("new interpreter error")
-	ErrNoSuchSlot          = errors.New("no such slot")
+	ErrRegisterNotFunction      = errors.New("register not function")
+	ErrRegisterNotObject        = errors.New("register not object")
+	ErrRegisterNoSchedule       = errors.New("register neither cron nor interval")
+	ErrRegisterBadCron          = errors.New("register cron unparsable")
+	ErrRegisterBadOverlap       = errors.New("register overlap neither skip nor queue")
+	ErrRegisterNoChannel        = errors.New("register neither channel nor pattern")
+	ErrRegisterBadBatch         = errors.New("register batch size not positive")
+	ErrRegisterBadConcurrency   = errors.New("register concurrency not positive")
+	ErrRegisterBatchConcurrency = errors.New("register both batch and concurrency")
+	ErrRegisterBadEncoding      = errors.New("register encoding neither none nor base64")
+	ErrRegisterDupRoute         = errors.New("register duplicate route")
+	ErrRegisterDupConsume       = errors.New("register duplicate consume")
+	ErrRegisterDupSchedule      = errors.New("register duplicate schedule")
+	ErrNewInterpreter           = errors.New("new interpreter error")
+	ErrNoSuchSlot               = errors.New("no such slot")
+	ErrNoSuchRoute              = errors.New("no such route")
+	ErrTimeout                  = errors.New("execution timeout")
+	ErrPoolExhausted            = errors.New("pool exhausted")
+	ErrConflict                 = errors.New("conflict")
+	ErrHandleFailed             = errors.New("handle failed")
 )
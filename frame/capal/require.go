@@ -5,7 +5,8 @@ import (
 	"github.com/jumboframes/tigerbalm/frame/capal/tbhttp"
 	"github.com/jumboframes/tigerbalm/frame/capal/tbkafka"
 	"github.com/jumboframes/tigerbalm/frame/capal/tblog"
-	"github.com/robertkrimen/otto"
+	"github.com/jumboframes/tigerbalm/frame/capal/tbredis"
+	"github.com/jumboframes/tigerbalm/frame/engine"
 )
 
 const (
@@ -13,26 +14,33 @@ const (
 	ModuleLog      = "log"
 	ModuleProducer = "producer"
 	ModuleEnv      = "env"
+	ModuleRedis    = "redis"
 )
 
 type Capal struct {
-	httpFactory func(ctx *PluginContext) *tbhttp.TbHttp
-	logFactory  func(ctx *PluginContext) *tblog.TbLog
+	httpFactory     func(ctx *PluginContext) *tbhttp.TbHttp
+	logFactory      func(ctx *PluginContext) *tblog.TbLog
+	redisFactory    func(ctx *PluginContext) *tbredis.TbRedis
+	producerFactory func(ctx *PluginContext) (*tbkafka.TbProducer, error)
 }
 
 func NewCapal(httpFactory func(ctx *PluginContext) *tbhttp.TbHttp,
-	logFactory func(ctx *PluginContext) *tblog.TbLog) *Capal {
+	logFactory func(ctx *PluginContext) *tblog.TbLog,
+	redisFactory func(ctx *PluginContext) *tbredis.TbRedis,
+	producerFactory func(ctx *PluginContext) (*tbkafka.TbProducer, error)) *Capal {
 	return &Capal{
-		httpFactory: httpFactory,
-		logFactory:  logFactory,
+		httpFactory:     httpFactory,
+		logFactory:      logFactory,
+		redisFactory:    redisFactory,
+		producerFactory: producerFactory,
 	}
 }
 
-func (capal *Capal) Require(call otto.FunctionCall) otto.Value {
+func (capal *Capal) Require(call engine.FunctionCall) engine.Value {
 	ctx, err := getPluginContext(call)
 	if err != nil {
 		tblog.Errorf("require | get plugin context err: %s", err)
-		return otto.NullValue()
+		return call.VM.Null()
 	}
 
 	log := capal.logFactory(ctx)
@@ -42,66 +50,81 @@ func (capal *Capal) Require(call otto.FunctionCall) otto.Value {
 	argc := len(call.ArgumentList)
 	if argc != 1 {
 		log.Errorf("require args != 1, callee: %s, line: %d",
-			call.Otto.Context().Callee, call.Otto.Context().Line)
-		return otto.NullValue()
+			call.Callee, call.Line)
+		return call.VM.Null()
 	}
 	module := call.ArgumentList[0].String()
 	switch module {
 	case ModuleHttp:
 		http := capal.httpFactory(ctx)
-		value, err := otto.New().ToValue(http)
+		value, err := call.VM.ToValue(http.Object())
 		if err != nil {
 			log.Errorf("require http err: %s, callee: %s, line: %d",
-				err, call.Otto.Context().Callee, call.Otto.Context().Line)
-			return otto.NullValue()
+				err, call.Callee, call.Line)
+			return call.VM.Null()
 		}
 		return value
 
 	case ModuleProducer:
-		producer, err := tbkafka.NewTbProducer()
+		producer, err := capal.producerFactory(ctx)
 		if err != nil {
 			log.Errorf("require producer err: %s, callee: %s, line: %d",
-				err, call.Otto.Context().Callee, call.Otto.Context().Line)
-			return otto.NullValue()
+				err, call.Callee, call.Line)
+			return call.VM.Null()
 		}
-		value, err := otto.New().ToValue(producer)
+		value, err := call.VM.ToValue(producer.Object())
 		if err != nil {
 			log.Errorf("producer to js err: %s, callee: %s, line: %d",
-				err, call.Otto.Context().Callee, call.Otto.Context().Line)
-			return otto.NullValue()
+				err, call.Callee, call.Line)
+			return call.VM.Null()
 		}
 		return value
 
 	case ModuleLog:
-		logOtto := tblog.NewTbLogOtto(log)
-		value, err := otto.New().ToValue(logOtto)
+		logJS := tblog.NewTbLogJS(log)
+		value, err := call.VM.ToValue(logJS.Object())
 		if err != nil {
 			log.Errorf("require log err: %s, callee: %s, line: %d",
-				err, call.Otto.Context().Callee, call.Otto.Context().Line)
-			return otto.NullValue()
+				err, call.Callee, call.Line)
+			return call.VM.Null()
+		}
+		return value
+
+	case ModuleRedis:
+		redis := capal.redisFactory(ctx)
+		if redis == nil {
+			log.Errorf("require redis not enabled, callee: %s, line: %d",
+				call.Callee, call.Line)
+			return call.VM.Null()
+		}
+		value, err := call.VM.ToValue(redis.Object())
+		if err != nil {
+			log.Errorf("require redis err: %s, callee: %s, line: %d",
+				err, call.Callee, call.Line)
+			return call.VM.Null()
 		}
 		return value
 
 	case ModuleEnv:
 		env := &tbenv.TbEnv{}
-		value, err := otto.New().ToValue(env)
+		value, err := call.VM.ToValue(env.Object())
 		if err != nil {
 			log.Errorf("require env err: %s, callee: %s, line: %d",
-				err, call.Otto.Context().Callee, call.Otto.Context().Line)
-			return otto.NullValue()
+				err, call.Callee, call.Line)
+			return call.VM.Null()
 		}
 		return value
 	}
 	log.Error("require unsupported module")
-	return otto.NullValue()
+	return call.VM.Null()
 }
 
-func getPluginContext(call otto.FunctionCall) (*PluginContext, error) {
-	context, err := call.Otto.Get("context")
+func getPluginContext(call engine.FunctionCall) (*PluginContext, error) {
+	context, err := call.VM.Get("context")
 	if err != nil {
 		return nil, err
 	}
-	name, err := context.Object().Get("Name")
+	name, err := context.Get("Name")
 	if err != nil {
 		return nil, err
 	}
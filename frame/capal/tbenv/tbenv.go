@@ -2,28 +2,34 @@ package tbenv
 
 import (
 	"github.com/jumboframes/tigerbalm"
-	"github.com/robertkrimen/otto"
+	"github.com/jumboframes/tigerbalm/frame/engine"
 )
 
 type TbEnv struct{}
 
-func (tbenv *TbEnv) Get(call otto.FunctionCall) otto.Value {
+func (tbenv *TbEnv) Object() engine.Object {
+	return engine.Object{
+		"Get": tbenv.Get,
+	}
+}
+
+func (tbenv *TbEnv) Get(call engine.FunctionCall) engine.Value {
 	argc := len(call.ArgumentList)
 	if argc != 1 {
-		return otto.NullValue()
+		return call.VM.Null()
 	}
 	name, err := call.ArgumentList[0].ToString()
 	if err != nil {
-		return otto.NullValue()
+		return call.VM.Null()
 	}
 	for _, env := range tigerbalm.Conf.Env {
 		if name == env.Name {
-			value, err := otto.ToValue(env.Value)
+			value, err := call.VM.ToValue(env.Value)
 			if err != nil {
-				return otto.NullValue()
+				return call.VM.Null()
 			}
 			return value
 		}
 	}
-	return otto.NullValue()
+	return call.VM.Null()
 }
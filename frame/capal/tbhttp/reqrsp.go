@@ -2,53 +2,95 @@ package tbhttp
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"net/url"
+	"strings"
 
-	"github.com/robertkrimen/otto"
+	"github.com/jumboframes/tigerbalm/frame/engine"
 )
 
 var (
 	ErrNoMethod = errors.New("no method")
 )
 
+const (
+	ContentTypeJson = "application/json; charset=utf-8"
+)
+
 type Request struct {
 	Method string
 	Host   string
 	Url    string
-	Query  map[string]string
-	Header map[string]string
-	Body   string
+	// values of path parameters like {id:int}, {p:path}
+	Params map[string]string
+	// Query and Header keep the first value, all values are in QueryValues
+	// and HeaderValues
+	Query        map[string]string
+	QueryValues  map[string][]string
+	Header       map[string]string
+	HeaderValues map[string][]string
+	Body         string
+	// parsed Body if the Content-Type is json
+	Json interface{}
+	// base64 if Body is encoded for binary safety
+	Encoding string
 }
 
+// a json body is parsed, malformed json fails
 func HttpReq2TbReq(req *http.Request) (*Request, error) {
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
 		return nil, err
 	}
 	tbReq := &Request{
-		Method: req.Method,
-		Url:    req.URL.Path,
-		Query:  map[string]string{},
-		Header: map[string]string{},
-		Host:   req.Host,
-		Body:   string(body),
+		Method:       req.Method,
+		Url:          req.URL.Path,
+		Params:       map[string]string{},
+		Query:        map[string]string{},
+		QueryValues:  req.URL.Query(),
+		Header:       map[string]string{},
+		HeaderValues: req.Header,
+		Host:         req.Host,
+		Body:         string(body),
 	}
 	for k, v := range req.Header {
 		tbReq.Header[k] = v[0]
 	}
-	for k, v := range req.URL.Query() {
+	for k, v := range tbReq.QueryValues {
 		tbReq.Query[k] = v[0]
 	}
+	if len(body) != 0 && isJson(req.Header.Get("Content-Type")) {
+		err = json.Unmarshal(body, &tbReq.Json)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return tbReq, nil
 }
 
+// application/json or types like application/problem+json
+func isJson(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
 type Response struct {
 	Status int
-	Header map[string]string
-	Body   string
+	// Header keeps the first value, all values are in HeaderValues
+	Header       map[string]string
+	HeaderValues map[string][]string
+	Body         string
+	// base64 if Body is encoded for binary safety
+	Encoding string
 }
 
 func HttpRsp2TbRsp(rsp *http.Response) (*Response, error) {
@@ -58,8 +100,9 @@ func HttpRsp2TbRsp(rsp *http.Response) (*Response, error) {
 	}
 
 	tbRsp := &Response{
-		Status: rsp.StatusCode,
-		Header: map[string]string{},
+		Status:       rsp.StatusCode,
+		Header:       map[string]string{},
+		HeaderValues: rsp.Header,
 	}
 	for k, v := range rsp.Header {
 		tbRsp.Header[k] = v[0]
@@ -79,16 +122,18 @@ func HttpRsp2TbRsp(rsp *http.Response) (*Response, error) {
 	"Path": "/",
 	"Query": {
 		"type": "movie",
+		"tag": ["a", "b"]
 	},
 	"Header": {
 		"X-REAL-IP": "192.168.180.56"
 	},
-	"Body": ""
+	"Body": "", // an object or array is sent as json
+	"Encoding": "base64" // optional, Body is decoded if set
 }
 */
-func OttoValue2HttpReq(req otto.Value) (*http.Request, error) {
+func Value2HttpReq(req engine.Value) (*http.Request, error) {
 	// method
-	value, err := req.Object().Get("Method")
+	value, err := req.Get("Method")
 	if err != nil {
 		return nil, err
 	}
@@ -97,7 +142,7 @@ func OttoValue2HttpReq(req otto.Value) (*http.Request, error) {
 		return nil, err
 	}
 	// host
-	value, err = req.Object().Get("Host")
+	value, err = req.Get("Host")
 	if err != nil {
 		return nil, err
 	}
@@ -106,7 +151,7 @@ func OttoValue2HttpReq(req otto.Value) (*http.Request, error) {
 		return nil, err
 	}
 	// path
-	value, err = req.Object().Get("Path")
+	value, err = req.Get("Path")
 	if err != nil {
 		return nil, err
 	}
@@ -114,59 +159,64 @@ func OttoValue2HttpReq(req otto.Value) (*http.Request, error) {
 	if err != nil {
 		return nil, err
 	}
-	url := concat(ProtoHttp, host, path)
+	rawUrl := concat(ProtoHttp, host, path)
 
-	// query
-	value, err = req.Object().Get("Query")
+	// query, escaped
+	value, err = req.Get("Query")
 	if err != nil {
 		return nil, err
 	}
 	if value.IsDefined() {
-		object := value.Object()
-		for index, key := range object.Keys() {
-			query, err := object.Get(key)
+		query := url.Values{}
+		for _, key := range value.Keys() {
+			elems, err := value.Get(key)
 			if err != nil {
 				continue
 			}
-			if query.IsString() {
-				if index == 0 {
-					url += "?" + key + "=" + query.String()
-				} else {
-					url += "&" + key + "=" + query.String()
-				}
+			for _, elem := range stringValues(elems) {
+				query.Add(key, elem)
 			}
 		}
+		if len(query) != 0 {
+			rawUrl += "?" + query.Encode()
+		}
 	}
 	// header
 	header := http.Header{}
-	value, err = req.Object().Get("Header")
+	value, err = req.Get("Header")
 	if err != nil {
 		return nil, err
 	}
 	if value.IsDefined() {
-		object := value.Object()
-		for _, key := range object.Keys() {
-			hdr, err := object.Get(key)
+		for _, key := range value.Keys() {
+			hdr, err := value.Get(key)
 			if err != nil {
 				continue
 			}
-			if hdr.IsString() {
-				header.Set(key, hdr.String())
+			for _, elem := range stringValues(hdr) {
+				header.Add(key, elem)
 			}
 		}
 	}
 	// body
 	body := io.Reader(nil)
-	value, err = req.Object().Get("Body")
+	value, err = req.Get("Body")
 	if err != nil {
 		return nil, err
 	}
 	if value.IsDefined() {
-		body = bytes.NewBuffer([]byte(value.String()))
+		data, isJson, err := bodyData(req, value)
+		if err != nil {
+			return nil, err
+		}
+		if isJson && header.Get("Content-Type") == "" {
+			header.Set("Content-Type", ContentTypeJson)
+		}
+		body = bytes.NewBuffer(data)
 	}
 
 	// request
-	httpReq, err := http.NewRequest(method, url, body)
+	httpReq, err := http.NewRequest(method, rawUrl, body)
 	if err != nil {
 		return nil, err
 	}
@@ -178,15 +228,17 @@ func OttoValue2HttpReq(req otto.Value) (*http.Request, error) {
 {
 	"status": 200,
 	"header": {
-		"Content-Type": "text/json"
+		"Content-Type": "text/json",
+		"Set-Cookie": ["a=1", "b=2"]
 	},
-	"body": "{'foo': "bar"}"
+	"body": "{'foo': "bar"}", // an object or array is sent as json
+	"encoding": "base64" // optional, body is decoded if set
 }
 */
-func OttoValue2TbRsp(rsp otto.Value) (*Response, error) {
+func Value2TbRsp(rsp engine.Value) (*Response, error) {
 	// status
 	status := http.StatusOK
-	value, err := rsp.Object().Get("Status")
+	value, err := rsp.Get("Status")
 	if err != nil {
 		return nil, err
 	}
@@ -199,38 +251,128 @@ func OttoValue2TbRsp(rsp otto.Value) (*Response, error) {
 	}
 	// header
 	header := map[string]string{}
-	value, err = rsp.Object().Get("Header")
+	headerValues := map[string][]string{}
+	value, err = rsp.Get("Header")
 	if err != nil {
 		return nil, err
 	}
 	if value.IsDefined() {
-		for _, key := range value.Object().Keys() {
-			v, err := value.Object().Get(key)
+		for _, key := range value.Keys() {
+			v, err := value.Get(key)
 			if err != nil {
 				continue
 			}
-			elem, err := v.ToString()
-			if err != nil {
+			elems := stringValues(v)
+			if len(elems) == 0 {
 				continue
 			}
-			header[key] = elem
+			header[key] = elems[0]
+			headerValues[key] = elems
 		}
 	}
 	// body
 	body := ""
-	value, err = rsp.Object().Get("Body")
+	value, err = rsp.Get("Body")
 	if err != nil {
 		return nil, err
 	}
 	if value.IsDefined() {
-		body, err = value.ToString()
+		data, isJson, err := bodyData(rsp, value)
 		if err != nil {
 			return nil, err
 		}
+		if isJson && !hasHeader(header, "Content-Type") {
+			header["Content-Type"] = ContentTypeJson
+			headerValues["Content-Type"] = []string{ContentTypeJson}
+		}
+		body = string(data)
+	}
+	return &Response{status, header, headerValues, body, engine.EncodingNone}, nil
+}
+
+// bodyData marshals an object or array body to json, otherwise decodes the
+// body by the encoding of obj
+func bodyData(obj, body engine.Value) ([]byte, bool, error) {
+	if body.IsObject() {
+		exported, err := body.Export()
+		if err != nil {
+			return nil, false, err
+		}
+		data, err := json.Marshal(exported)
+		return data, true, err
+	}
+	str, err := body.ToString()
+	if err != nil {
+		return nil, false, err
+	}
+	encoding, err := engine.GetEncoding(obj)
+	if err != nil {
+		return nil, false, err
+	}
+	data, err := engine.Decode(str, encoding)
+	return data, false, err
+}
+
+func hasHeader(header map[string]string, key string) bool {
+	for k := range header {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// stringValues takes a value or an array of values, undefined is skipped
+func stringValues(value engine.Value) []string {
+	if !value.IsDefined() {
+		return nil
+	}
+	if !value.IsObject() {
+		elem, err := value.ToString()
+		if err != nil {
+			return nil
+		}
+		return []string{elem}
 	}
-	return &Response{status, header, body}, nil
+	exported, err := value.Export()
+	if err != nil {
+		return nil
+	}
+	switch elems := exported.(type) {
+	case []string:
+		return elems
+	case []interface{}:
+		values := make([]string, 0, len(elems))
+		for _, elem := range elems {
+			values = append(values, fmt.Sprint(elem))
+		}
+		return values
+	}
+	return nil
+}
+
+func TbRsp2Value(vm engine.VM, rsp *Response) (engine.Value, error) {
+	return vm.ToValue(rsp)
 }
 
-func TbRsp2OttoValue(rsp *Response) (otto.Value, error) {
-	return otto.New().ToValue(rsp)
+// EncodeBody encodes Body for binary safety
+func (req *Request) EncodeBody(encoding string) error {
+	body, err := engine.Encode([]byte(req.Body), encoding)
+	if err != nil {
+		return err
+	}
+	req.Body = body
+	req.Encoding = encoding
+	return nil
+}
+
+// EncodeBody encodes Body for binary safety
+func (rsp *Response) EncodeBody(encoding string) error {
+	body, err := engine.Encode([]byte(rsp.Body), encoding)
+	if err != nil {
+		return err
+	}
+	rsp.Body = body
+	rsp.Encoding = encoding
+	return nil
 }
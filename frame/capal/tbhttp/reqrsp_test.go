@@ -0,0 +1,258 @@
+package tbhttp
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jumboframes/tigerbalm/frame/engine"
+)
+
+// jsValue evaluates expr in a vm of the engine
+func jsValue(t *testing.T, name, expr string) engine.Value {
+	eng, err := engine.New(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	program, err := eng.Compile(name+".js", []byte("var value = "+expr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := eng.NewVM()
+	if err = vm.Run(program); err != nil {
+		t.Fatal(err)
+	}
+	value, err := vm.Get("value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func TestHttpReq2TbReq(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		header      http.Header
+		body        string
+		query       string // Query, then QueryValues
+		headerValue string // Header, then HeaderValues of X-Tag
+		json        string
+	}{{
+		name:        "multiple values",
+		url:         "/users?tag=a&tag=b%20c",
+		header:      http.Header{"X-Tag": {"a", "b"}},
+		query:       "a [a b c]",
+		headerValue: "a [a b]",
+		json:        "<nil>",
+	}, {
+		name:   "json",
+		url:    "/users",
+		header: http.Header{"Content-Type": {"application/json; charset=utf-8"}},
+		body:   `{"a": [1, "b"]}`,
+		json:   "map[a:[1 b]]",
+	}, {
+		name:   "json suffix",
+		url:    "/users",
+		header: http.Header{"Content-Type": {"application/problem+json"}},
+		body:   `{"a": 1}`,
+		json:   "map[a:1]",
+	}, {
+		name:   "not json",
+		url:    "/users",
+		header: http.Header{"Content-Type": {"text/plain"}},
+		body:   `{"a": 1}`,
+		json:   "<nil>",
+	}}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodPost, test.url, strings.NewReader(test.body))
+		req.Header = test.header
+		tbReq, err := HttpReq2TbReq(req)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if tbReq.Body != test.body {
+			t.Errorf("%s: unexpected body: %s", test.name, tbReq.Body)
+		}
+		if test.query != "" {
+			query := fmt.Sprint(tbReq.Query["tag"], " ", tbReq.QueryValues["tag"])
+			if query != test.query {
+				t.Errorf("%s: unexpected query: %s", test.name, query)
+			}
+		}
+		if test.headerValue != "" {
+			header := fmt.Sprint(tbReq.Header["X-Tag"], " ", tbReq.HeaderValues["X-Tag"])
+			if header != test.headerValue {
+				t.Errorf("%s: unexpected header: %s", test.name, header)
+			}
+		}
+		if json := fmt.Sprint(tbReq.Json); json != test.json {
+			t.Errorf("%s: unexpected json: %s", test.name, json)
+		}
+	}
+}
+
+func TestRequestValue(t *testing.T) {
+	tbReq := &Request{
+		Params:       map[string]string{"id": "42"},
+		Query:        map[string]string{"tag": "a"},
+		QueryValues:  map[string][]string{"tag": {"a", "b"}},
+		Header:       map[string]string{"X-Tag": "c"},
+		HeaderValues: map[string][]string{"X-Tag": {"c", "d"}},
+		Body:         string([]byte{0, 0xff}),
+		Json:         map[string]interface{}{"a": []interface{}{1.0, "b"}},
+	}
+	if err := tbReq.EncodeBody(engine.EncodingBase64); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{engine.EngineOtto, engine.EngineGoja} {
+		eng, _ := engine.New(name)
+		program, err := eng.Compile(name+".js", []byte(`
+			function run(request) {
+				return [request.Params.id, request.Query.tag, request.QueryValues.tag[1],
+					request.HeaderValues["X-Tag"][1], request.Json.a[1],
+					request.Body, request.Encoding].join("|")
+			}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		vm := eng.NewVM()
+		if err = vm.Run(program); err != nil {
+			t.Fatal(err)
+		}
+		run, _ := vm.Get("run")
+		value, err := vm.Call(run, tbReq)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if value.String() != "42|a|b|d|b|AP8=|base64" {
+			t.Errorf("%s: unexpected value: %s", name, value.String())
+		}
+	}
+}
+
+func TestValue2HttpReq(t *testing.T) {
+	tests := []struct {
+		name        string
+		expr        string
+		url         string
+		header      string
+		contentType string
+		body        []byte
+	}{{
+		name: "escaped query",
+		expr: `{"Method": "GET", "Host": "foo", "Path": "/bar",
+			"Query": {"q": "a b&c=d", "tag": ["x", "y"]}}`,
+		url: "http://foo/bar?q=a+b%26c%3Dd&tag=x&tag=y",
+	}, {
+		name: "multiple headers",
+		expr: `{"Method": "GET", "Host": "foo", "Path": "/bar",
+			"Header": {"X-Tag": ["a", "b"]}}`,
+		url:    "http://foo/bar",
+		header: "[a b]",
+	}, {
+		name: "base64 body",
+		expr: `{"Method": "POST", "Host": "foo", "Path": "/bar",
+			"Body": "AP8=", "Encoding": "base64"}`,
+		url:  "http://foo/bar",
+		body: []byte{0, 0xff},
+	}, {
+		name: "json body",
+		expr: `{"Method": "POST", "Host": "foo", "Path": "/bar",
+			"Body": {"a": [1, "b"]}}`,
+		url:         "http://foo/bar",
+		contentType: ContentTypeJson,
+		body:        []byte(`{"a":[1,"b"]}`),
+	}, {
+		name: "json body of content type",
+		expr: `{"Method": "POST", "Host": "foo", "Path": "/bar",
+			"Header": {"Content-Type": "application/problem+json"}, "Body": ["a"]}`,
+		url:         "http://foo/bar",
+		contentType: "application/problem+json",
+		body:        []byte(`["a"]`),
+	}}
+	for _, name := range []string{engine.EngineOtto, engine.EngineGoja} {
+		for _, test := range tests {
+			req, err := Value2HttpReq(jsValue(t, name, test.expr))
+			if err != nil {
+				t.Errorf("%s %s: %s", name, test.name, err)
+				continue
+			}
+			if req.URL.String() != test.url {
+				t.Errorf("%s %s: unexpected url: %s", name, test.name, req.URL.String())
+			}
+			if test.header != "" && fmt.Sprint(req.Header["X-Tag"]) != test.header {
+				t.Errorf("%s %s: unexpected header: %v", name, test.name, req.Header)
+			}
+			if req.Header.Get("Content-Type") != test.contentType {
+				t.Errorf("%s %s: unexpected content type: %s", name, test.name,
+					req.Header.Get("Content-Type"))
+			}
+			body := []byte{}
+			if req.Body != nil {
+				body, _ = ioutil.ReadAll(req.Body)
+			}
+			if !bytes.Equal(body, test.body) {
+				t.Errorf("%s %s: unexpected body: %v", name, test.name, body)
+			}
+		}
+	}
+}
+
+func TestValue2TbRsp(t *testing.T) {
+	tests := []struct {
+		name   string
+		expr   string
+		status int
+		header string
+		body   []byte
+	}{{
+		name:   "multiple headers",
+		expr:   `{"Status": 201, "Header": {"Set-Cookie": ["a=1", "b=2"]}, "Body": "foo"}`,
+		status: http.StatusCreated,
+		header: "a=1 [a=1 b=2]",
+		body:   []byte("foo"),
+	}, {
+		name:   "base64 body",
+		expr:   `{"Body": "AP8=", "Encoding": "base64"}`,
+		status: http.StatusOK,
+		body:   []byte{0, 0xff},
+	}, {
+		name:   "json body",
+		expr:   `{"Body": {"a": [1, "b"]}}`,
+		status: http.StatusOK,
+		body:   []byte(`{"a":[1,"b"]}`),
+	}}
+	for _, name := range []string{engine.EngineOtto, engine.EngineGoja} {
+		for _, test := range tests {
+			rsp, err := Value2TbRsp(jsValue(t, name, test.expr))
+			if err != nil {
+				t.Errorf("%s %s: %s", name, test.name, err)
+				continue
+			}
+			if rsp.Status != test.status {
+				t.Errorf("%s %s: unexpected status: %d", name, test.name, rsp.Status)
+			}
+			if test.header != "" {
+				header := fmt.Sprint(rsp.Header["Set-Cookie"], " ", rsp.HeaderValues["Set-Cookie"])
+				if header != test.header {
+					t.Errorf("%s %s: unexpected header: %s", name, test.name, header)
+				}
+			}
+			if !bytes.Equal([]byte(rsp.Body), test.body) {
+				t.Errorf("%s %s: unexpected body: %v", name, test.name, []byte(rsp.Body))
+			}
+		}
+	}
+	// a json body gets a json content type unless set
+	rsp, _ := Value2TbRsp(jsValue(t, engine.EngineGoja, `{"Body": {"a": 1}}`))
+	if rsp.Header["Content-Type"] != ContentTypeJson {
+		t.Errorf("unexpected header: %v", rsp.Header)
+	}
+}
@@ -0,0 +1,57 @@
+package tbhttp
+
+import (
+	"net/http"
+
+	"github.com/jumboframes/tigerbalm/frame/engine"
+)
+
+const (
+	ProtoHttp  = "http://"
+	ProtoHttps = "https://"
+)
+
+type TbHttp struct{}
+
+func (tbhttp *TbHttp) Object() engine.Object {
+	return engine.Object{
+		"DoRequest": tbhttp.DoRequest,
+	}
+}
+
+func (tbhttp *TbHttp) DoRequest(call engine.FunctionCall) engine.Value {
+	argc := len(call.ArgumentList)
+	if argc != 1 {
+		return call.VM.Null()
+	}
+	req, err := Value2HttpReq(call.ArgumentList[0])
+	if err != nil {
+		return call.VM.Null()
+	}
+	// the response body is encoded as the request body
+	encoding, err := engine.GetEncoding(call.ArgumentList[0])
+	if err != nil {
+		return call.VM.Null()
+	}
+
+	client := &http.Client{}
+	rowRsp, err := client.Do(req)
+	if err != nil {
+		return call.VM.Null()
+	}
+	defer rowRsp.Body.Close()
+
+	rsp, err := HttpRsp2TbRsp(rowRsp)
+	if err != nil {
+		return call.VM.Null()
+	}
+	if err = rsp.EncodeBody(encoding); err != nil {
+		return call.VM.Null()
+	}
+
+	value, err := call.VM.ToValue(rsp)
+	if err != nil {
+		return call.VM.Null()
+	}
+	return value
+}
@@ -0,0 +1,259 @@
+package tbhttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/jumboframes/tigerbalm/frame/engine"
+)
+
+const (
+	ContentTypeEventStream = "text/event-stream"
+)
+
+// ResponseWriter lets a handler stream the response, methods return false
+// once the client is gone or the handler returned.
+type ResponseWriter struct {
+	w           http.ResponseWriter
+	done        <-chan struct{}
+	onWrite     func()
+	wroteHeader bool
+	closed      bool
+}
+
+// done is closed when the client disconnects
+func NewResponseWriter(w http.ResponseWriter, done <-chan struct{}) *ResponseWriter {
+	return &ResponseWriter{w: w, done: done}
+}
+
+// Done is closed when the client disconnects
+func (writer *ResponseWriter) Done() <-chan struct{} {
+	return writer.done
+}
+
+// OnWrite registers fn called after each successful write
+func (writer *ResponseWriter) OnWrite(fn func()) {
+	writer.onWrite = fn
+}
+
+// Written tells if the handler started the response, its return value is
+// ignored then
+func (writer *ResponseWriter) Written() bool {
+	return writer.wroteHeader
+}
+
+// Close stops further writes, a writer kept by javascript after the handler
+// returned must not touch the finished response
+func (writer *ResponseWriter) Close() {
+	writer.closed = true
+}
+
+func (writer *ResponseWriter) Object() engine.Object {
+	return engine.Object{
+		"writeHeader": writer.WriteHeader,
+		"write":       writer.Write,
+		"flush":       writer.Flush,
+		"sse":         writer.SSE,
+	}
+}
+
+// writeHeader(status, header), header values may be strings or arrays
+func (writer *ResponseWriter) WriteHeader(call engine.FunctionCall) engine.Value {
+	if !writer.writable() || writer.wroteHeader {
+		return writer.result(call, false)
+	}
+	status := http.StatusOK
+	value := call.Argument(0)
+	if value.IsDefined() {
+		status64, err := value.ToInteger()
+		if err != nil {
+			return writer.result(call, false)
+		}
+		status = int(status64)
+	}
+	value = call.Argument(1)
+	header := writer.w.Header()
+	for _, key := range value.Keys() {
+		hdr, err := value.Get(key)
+		if err != nil {
+			continue
+		}
+		header.Del(key)
+		for _, elem := range stringValues(hdr) {
+			header.Add(key, elem)
+		}
+	}
+	writer.writeHeader(status)
+	return writer.result(call, true)
+}
+
+// write(data, encoding), an object or array is written as json, a string is
+// decoded by the optional encoding
+func (writer *ResponseWriter) Write(call engine.FunctionCall) engine.Value {
+	if !writer.writable() {
+		return writer.result(call, false)
+	}
+	encoding := engine.EncodingNone
+	if value := call.Argument(1); value.IsDefined() {
+		var err error
+		encoding, err = value.ToString()
+		if err != nil {
+			return writer.result(call, false)
+		}
+	}
+	data, err := writeData(call.Argument(0), encoding)
+	if err != nil {
+		return writer.result(call, false)
+	}
+	return writer.result(call, writer.write(data))
+}
+
+// flush() sends what's written to the client
+func (writer *ResponseWriter) Flush(call engine.FunctionCall) engine.Value {
+	if !writer.writable() {
+		return writer.result(call, false)
+	}
+	return writer.result(call, writer.flush())
+}
+
+/*
+sse({
+	"event": "progress", // optional
+	"id": "42", // optional
+	"retry": 3000, // optional, in milliseconds
+	"data": {"done": 42} // an object or array is sent as json
+})
+a string is sent as data, the event is flushed right away
+*/
+func (writer *ResponseWriter) SSE(call engine.FunctionCall) engine.Value {
+	if !writer.writable() {
+		return writer.result(call, false)
+	}
+	event, err := sseEvent(call.Argument(0))
+	if err != nil {
+		return writer.result(call, false)
+	}
+	if !writer.wroteHeader {
+		header := writer.w.Header()
+		header.Set("Content-Type", ContentTypeEventStream)
+		header.Set("Cache-Control", "no-cache")
+		header.Set("Connection", "keep-alive")
+	}
+	return writer.result(call, writer.write(event) && writer.flush())
+}
+
+func (writer *ResponseWriter) writable() bool {
+	if writer.closed {
+		return false
+	}
+	select {
+	case <-writer.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (writer *ResponseWriter) writeHeader(status int) {
+	writer.w.WriteHeader(status)
+	writer.wroteHeader = true
+	writer.wrote()
+}
+
+func (writer *ResponseWriter) write(data []byte) bool {
+	if !writer.wroteHeader {
+		writer.writeHeader(http.StatusOK)
+	}
+	_, err := writer.w.Write(data)
+	if err != nil {
+		return false
+	}
+	writer.wrote()
+	return true
+}
+
+func (writer *ResponseWriter) flush() bool {
+	flusher, ok := writer.w.(http.Flusher)
+	if !ok {
+		return false
+	}
+	if !writer.wroteHeader {
+		writer.writeHeader(http.StatusOK)
+	}
+	flusher.Flush()
+	writer.wrote()
+	return true
+}
+
+func (writer *ResponseWriter) wrote() {
+	if writer.onWrite != nil {
+		writer.onWrite()
+	}
+}
+
+func (writer *ResponseWriter) result(call engine.FunctionCall, ok bool) engine.Value {
+	value, err := call.VM.ToValue(ok)
+	if err != nil {
+		return call.VM.Null()
+	}
+	return value
+}
+
+func writeData(data engine.Value, encoding string) ([]byte, error) {
+	if data.IsObject() {
+		exported, err := data.Export()
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(exported)
+	}
+	str, err := data.ToString()
+	if err != nil {
+		return nil, err
+	}
+	return engine.Decode(str, encoding)
+}
+
+// sseEvent formats an event, data of multiple lines takes multiple data
+// fields
+func sseEvent(value engine.Value) ([]byte, error) {
+	builder := strings.Builder{}
+	data := value
+	if value.IsObject() && !value.IsArray() {
+		for _, field := range []string{"id", "event", "retry"} {
+			fieldValue, err := value.Get(field)
+			if err != nil {
+				return nil, err
+			}
+			if !fieldValue.IsDefined() {
+				continue
+			}
+			str, err := fieldValue.ToString()
+			if err != nil {
+				return nil, err
+			}
+			builder.WriteString(field + ": " + oneLine(str) + "\n")
+		}
+		var err error
+		data, err = value.Get("data")
+		if err != nil {
+			return nil, err
+		}
+	}
+	if data.IsDefined() {
+		bytes, err := writeData(data, engine.EncodingNone)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(bytes), "\n") {
+			builder.WriteString("data: " + strings.TrimSuffix(line, "\r") + "\n")
+		}
+	}
+	builder.WriteString("\n")
+	return []byte(builder.String()), nil
+}
+
+func oneLine(str string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(str)
+}
@@ -0,0 +1,126 @@
+package tbhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jumboframes/tigerbalm/frame/engine"
+)
+
+// runWriter calls handler(w) of src with a writer on rec
+func runWriter(t *testing.T, name, src string, rec http.ResponseWriter,
+	done <-chan struct{}) (*ResponseWriter, engine.VM, engine.Value) {
+
+	eng, err := engine.New(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	program, err := eng.Compile(name+".js", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := eng.NewVM()
+	if err = vm.Run(program); err != nil {
+		t.Fatal(err)
+	}
+	writer := NewResponseWriter(rec, done)
+	handler, _ := vm.Get("handler")
+	value, err := vm.Call(handler, writer.Object())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+	return writer, vm, value
+}
+
+func TestResponseWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		status int
+		header http.Header
+		body   string
+	}{{
+		name: "write",
+		src: `function handler(w) {
+			w.writeHeader(201, {"X-Tag": ["a", "b"]})
+			w.write("foo\n")
+			w.write({"a": [1, "b"]})
+			w.write("AP8=", "base64")
+		}`,
+		status: http.StatusCreated,
+		header: http.Header{"X-Tag": {"a", "b"}},
+		body:   "foo\n{\"a\":[1,\"b\"]}\x00\xff",
+	}, {
+		name: "sse",
+		src: `function handler(w) {
+			w.sse("foo")
+			w.sse({"event": "progress", "id": "4\n2", "retry": 3000, "data": "a\nb"})
+			w.sse({"data": {"done": 42}})
+		}`,
+		status: http.StatusOK,
+		header: http.Header{
+			"Content-Type":  {ContentTypeEventStream},
+			"Cache-Control": {"no-cache"},
+		},
+		body: "data: foo\n\n" +
+			"id: 42\nevent: progress\nretry: 3000\ndata: a\ndata: b\n\n" +
+			"data: {\"done\":42}\n\n",
+	}}
+	for _, name := range []string{engine.EngineOtto, engine.EngineGoja} {
+		for _, test := range tests {
+			rec := httptest.NewRecorder()
+			writer, _, _ := runWriter(t, name, test.src, rec, nil)
+			if !writer.Written() {
+				t.Errorf("%s %s: not written", name, test.name)
+			}
+			if rec.Code != test.status {
+				t.Errorf("%s %s: unexpected status: %d", name, test.name, rec.Code)
+			}
+			for key, values := range test.header {
+				if got := rec.Header()[key]; len(got) != len(values) || got[0] != values[0] ||
+					got[len(got)-1] != values[len(values)-1] {
+					t.Errorf("%s %s: unexpected header %s: %v", name, test.name, key, got)
+				}
+			}
+			if rec.Body.String() != test.body {
+				t.Errorf("%s %s: unexpected body: %q", name, test.name, rec.Body.String())
+			}
+		}
+	}
+}
+
+func TestResponseWriterDisconnect(t *testing.T) {
+	for _, name := range []string{engine.EngineOtto, engine.EngineGoja} {
+		// writes fail once the client is gone, a writing loop ends
+		rec := httptest.NewRecorder()
+		done := make(chan struct{})
+		time.AfterFunc(20*time.Millisecond, func() { close(done) })
+		_, _, value := runWriter(t, name, `function handler(w) {
+			var writes = 0
+			while (w.write("x")) {
+				writes++
+			}
+			return writes > 0 && !w.flush() && !w.sse("foo")
+		}`, rec, done)
+		if value.String() != "true" {
+			t.Errorf("%s: unexpected value: %s", name, value.String())
+		}
+
+		// a writer kept after the handler returns writes nothing
+		rec = httptest.NewRecorder()
+		writer, vm, _ := runWriter(t, name, `var kept
+			function handler(w) { kept = w }
+			function later() { return kept.write("x") }`, rec, nil)
+		later, _ := vm.Get("later")
+		value, err := vm.Call(later)
+		if err != nil || value.String() != "false" {
+			t.Errorf("%s: unexpected value: %v, err: %v", name, value, err)
+		}
+		if writer.Written() || rec.Body.Len() != 0 {
+			t.Errorf("%s: unexpected written: %q", name, rec.Body.String())
+		}
+	}
+}
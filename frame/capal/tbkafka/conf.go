@@ -0,0 +1,121 @@
+package tbkafka
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/jumboframes/tigerbalm"
+)
+
+// ProducerOptionsFromConf builds version, security, compression, acks and
+// flush options from tigerbalm.yaml
+func ProducerOptionsFromConf() ([]ProducerOption, error) {
+	conf := &tigerbalm.Conf.Kafka
+	options := []ProducerOption{}
+	if conf.Version != "" {
+		version, err := sarama.ParseKafkaVersion(conf.Version)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, OptionVersion(version))
+	}
+	if conf.Sasl.Enable {
+		if err := checkSaslMechanism(conf.Sasl.Mechanism); err != nil {
+			return nil, err
+		}
+		options = append(options,
+			OptionSaslMechanism(conf.Sasl.Mechanism, conf.Sasl.User, conf.Sasl.Password))
+	}
+	if conf.TLS.Enable {
+		config, err := NewTLSConfig(conf.TLS.CA, conf.TLS.Cert, conf.TLS.Key,
+			conf.TLS.InsecureSkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, OptionNetTLS(config))
+	}
+	if conf.Producer.Compression != "" {
+		compression, err := parseCompression(conf.Producer.Compression)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, OptionCompression(compression))
+	}
+	if conf.Producer.Acks != "" {
+		acks, err := parseAcks(conf.Producer.Acks)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, OptionRequiredAcks(acks))
+	}
+	flush := conf.Producer.Flush
+	if flush.Bytes > 0 {
+		options = append(options, OptionFlushBytes(flush.Bytes))
+	}
+	if flush.Messages > 0 {
+		options = append(options, OptionFlushMessages(flush.Messages))
+	}
+	if flush.Frequency > 0 {
+		options = append(options, OptionFlushFrequency(flush.Frequency*time.Millisecond))
+	}
+	if flush.MaxMessages > 0 {
+		options = append(options, OptionFlushMaxMessages(flush.MaxMessages))
+	}
+	return options, nil
+}
+
+// ConsumerGroupOptionsFromConf builds version and security options from
+// tigerbalm.yaml
+func ConsumerGroupOptionsFromConf() ([]ConsumerGroupOption, error) {
+	conf := &tigerbalm.Conf.Kafka
+	options := []ConsumerGroupOption{}
+	if conf.Version != "" {
+		options = append(options, OptionConsumerGroupVersion(conf.Version))
+	}
+	if conf.Sasl.Enable {
+		if err := checkSaslMechanism(conf.Sasl.Mechanism); err != nil {
+			return nil, err
+		}
+		options = append(options,
+			OptionConsumerGroupSasl(conf.Sasl.Mechanism, conf.Sasl.User, conf.Sasl.Password))
+	}
+	if conf.TLS.Enable {
+		config, err := NewTLSConfig(conf.TLS.CA, conf.TLS.Cert, conf.TLS.Key,
+			conf.TLS.InsecureSkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, OptionConsumerGroupNetTLS(config))
+	}
+	return options, nil
+}
+
+func parseCompression(name string) (CompressionType, error) {
+	switch strings.ToLower(name) {
+	case "none":
+		return CompressionNone, nil
+	case "gzip":
+		return CompressionGZIP, nil
+	case "snappy":
+		return CompressionSnappy, nil
+	case "lz4":
+		return CompressionLZ4, nil
+	case "zstd":
+		return CompressionZSTD, nil
+	}
+	return CompressionNone, errors.New("no such compression")
+}
+
+func parseAcks(name string) (RequireType, error) {
+	switch strings.ToLower(name) {
+	case "none":
+		return RequireNoResponse, nil
+	case "leader":
+		return RequireOnlyLeader, nil
+	case "all":
+		return RequireAllReplicas, nil
+	}
+	return RequireOnlyLeader, errors.New("no such acks")
+}
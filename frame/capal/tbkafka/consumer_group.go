@@ -2,8 +2,11 @@ package tbkafka
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"hash/fnv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Shopify/sarama"
@@ -14,6 +17,18 @@ var (
 	OffsetOldest = sarama.OffsetOldest
 )
 
+// returned by modes of a claim to go on in the new mode
+var errSwapped = errors.New("handler swapped")
+
+const (
+	defaultConsumeBackoff = time.Second
+	// before a failed message or batch is handled again
+	defaultRetryBackoff = time.Second
+	defaultBatchWindow  = time.Second
+	// messages in flight per lane
+	defaultLaneQueue = 16
+)
+
 type ConsumerGroupOption func(*ConsumerGroup) error
 
 func OptionConsumerGroupFailedCh(ch chan<- *ConsumerGroupMessage) ConsumerGroupOption {
@@ -36,7 +51,7 @@ func OptionConsumerGroupOffsetsInitial(initial int64) ConsumerGroupOption {
 }
 
 // 0.10.2.1
-// 2.8.0.0
+// 2.8.0
 func OptionConsumerGroupVersion(version string) ConsumerGroupOption {
 	return func(cg *ConsumerGroup) error {
 		kVersion, err := sarama.ParseKafkaVersion(version)
@@ -62,13 +77,47 @@ func OptionConsumerGroupHeartbeatInterval(d time.Duration) ConsumerGroupOption {
 	}
 }
 
+// plain, scram-sha-256 or scram-sha-512
+func OptionConsumerGroupSasl(mechanism, user, passwd string) ConsumerGroupOption {
+	return func(cg *ConsumerGroup) error {
+		return setSasl(cg.config, mechanism, user, passwd)
+	}
+}
+
+func OptionConsumerGroupNetTLS(config *tls.Config) ConsumerGroupOption {
+	return func(cg *ConsumerGroup) error {
+		cg.config.Net.TLS.Enable = true
+		cg.config.Net.TLS.Config = config
+		return nil
+	}
+}
+
 type ConsumerGroupMessage struct {
 	Topic         string
 	ConsumerGroup string
 	Partition     int32
 	Offset        int64
+	Key           []byte
+	Headers       []*sarama.RecordHeader
+	Timestamp     time.Time
 	Payload       []byte
-	Error         error
+	// done when the claim ends or the mode is swapped, a handler waiting
+	// to retry should give up then
+	Context context.Context
+	// set by handlers, the offset is not marked if failed
+	Error error
+}
+
+// a batch is handled as a whole, the offsets are not marked if failed
+type ConsumerGroupBatch struct {
+	Topic         string
+	ConsumerGroup string
+	Partition     int32
+	Messages      []*ConsumerGroupMessage
+	// done when the claim ends or the mode is swapped
+	Context context.Context
+	// set by handlers
+	Error error
 }
 
 type ConsumerGroup struct {
@@ -91,7 +140,8 @@ func NewConsumerGroup(addrs []string, options ...ConsumerGroupOption) (*Consumer
 		tps:    new(sync.Map),
 		quit:   false,
 	}
-	cg.config.Version = sarama.V0_10_2_1
+	// headers need 0.11.0.0 and later
+	cg.config.Version = sarama.V1_0_0_0
 	for _, option := range options {
 		err := option(cg)
 		if err != nil {
@@ -102,13 +152,55 @@ func NewConsumerGroup(addrs []string, options ...ConsumerGroupOption) (*Consumer
 	return cg, nil
 }
 
+// ConsumerGroupHandler handles messages of a topic and group, messages go to
+// Output if neither Handlers nor BatchHandler is set.
+type ConsumerGroupHandler struct {
+	Handlers []func(*ConsumerGroupMessage)
+	// BatchHandler handles messages of a claim in batches of Batch, or less
+	// if Window elapses since the first message of the batch, one second by
+	// default.
+	BatchHandler func(*ConsumerGroupBatch)
+	Batch        int
+	Window       time.Duration
+	// Concurrency lanes handle messages of a claim, messages of the same key
+	// go to the same lane and are handled in order. Offsets are marked up to
+	// the lowest unfinished message.
+	Concurrency int
+}
+
+func (handler *ConsumerGroupHandler) normalize() {
+	if handler.BatchHandler == nil {
+		return
+	}
+	if handler.Batch <= 0 {
+		handler.Batch = 1
+	}
+	if handler.Window <= 0 {
+		handler.Window = defaultBatchWindow
+	}
+}
+
+// sameMode tells if claims can go on with the other handler
+func (handler *ConsumerGroupHandler) sameMode(other *ConsumerGroupHandler) bool {
+	return (handler.BatchHandler == nil) == (other.BatchHandler == nil) &&
+		(len(handler.Handlers) == 0) == (len(other.Handlers) == 0) &&
+		handler.Batch == other.Batch && handler.Window == other.Window &&
+		handler.Concurrency == other.Concurrency
+}
+
 func (cg *ConsumerGroup) Add(topic string, group string,
 	handlers ...func(*ConsumerGroupMessage)) error {
+	return cg.AddHandler(topic, group, &ConsumerGroupHandler{Handlers: handlers})
+}
+
+func (cg *ConsumerGroup) AddHandler(topic string, group string,
+	handler *ConsumerGroupHandler) error {
 
 	key := topic + group
 	_, ok := cg.tps.Load(key)
 	if !ok {
-		w := newWorkerCG(cg, topic, group, handlers...)
+		handler.normalize()
+		w := newWorkerCG(cg, topic, group, handler)
 		_, loaded := cg.tps.LoadOrStore(key, w)
 		if !loaded {
 			err := w.spawn()
@@ -121,6 +213,22 @@ func (cg *ConsumerGroup) Add(topic string, group string,
 	return errors.New("topic existed")
 }
 
+// Swap replaces the handler behind a running topic and group without leaving
+// the group, claims go on in the new mode if the mode, like batch or
+// concurrency, changes.
+func (cg *ConsumerGroup) Swap(topic string, group string,
+	handler *ConsumerGroupHandler) error {
+
+	key := topic + group
+	v, ok := cg.tps.Load(key)
+	if !ok {
+		return errors.New("topic not exist")
+	}
+	handler.normalize()
+	v.(*workerCG).swap(handler)
+	return nil
+}
+
 func (cg *ConsumerGroup) Del(topic string, group string) error {
 	key := topic + group
 	v, ok := cg.tps.LoadAndDelete(key)
@@ -147,24 +255,47 @@ func (cg *ConsumerGroup) Fini() {
 }
 
 type workerCG struct {
-	quit     bool
-	cg       *ConsumerGroup
-	csr      sarama.ConsumerGroup
-	topic    string
-	group    string
-	handlers []func(*ConsumerGroupMessage) // optional
+	quit  bool
+	cg    *ConsumerGroup
+	csr   sarama.ConsumerGroup
+	topic string
+	group string
+
+	mu      sync.RWMutex
+	handler *ConsumerGroupHandler
+	// closed when the mode changes
+	swapped chan struct{}
+	backoff time.Duration
 }
 
 func newWorkerCG(cg *ConsumerGroup, topic string, group string,
-	handlers ...func(*ConsumerGroupMessage)) *workerCG {
+	handler *ConsumerGroupHandler) *workerCG {
 	return &workerCG{
-		cg:       cg,
-		topic:    topic,
-		group:    group,
-		handlers: handlers,
+		cg:      cg,
+		topic:   topic,
+		group:   group,
+		handler: handler,
+		swapped: make(chan struct{}),
+		backoff: defaultRetryBackoff,
 	}
 }
 
+func (w *workerCG) swap(handler *ConsumerGroupHandler) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.handler.sameMode(handler) {
+		close(w.swapped)
+		w.swapped = make(chan struct{})
+	}
+	w.handler = handler
+}
+
+func (w *workerCG) current() (*ConsumerGroupHandler, <-chan struct{}) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.handler, w.swapped
+}
+
 func (w *workerCG) spawn() error {
 	csr, err := sarama.NewConsumerGroup(w.cg.addrs, w.group, w.cg.config)
 	if err != nil {
@@ -187,6 +318,9 @@ func (w *workerCG) consume() {
 				Error:         err,
 			}
 		}
+		if err != nil && !w.cg.quit && !w.quit {
+			time.Sleep(defaultConsumeBackoff)
+		}
 	}
 }
 
@@ -210,34 +344,383 @@ func (w *workerCG) Cleanup(sess sarama.ConsumerGroupSession) error {
 	return nil
 }
 
+// handlers are looked up for every message, a claim goes on in the new mode
+// if the mode is swapped, messages unhandled by the old mode come first. A
+// failed message is handled again in the claim without marking, so the group
+// isn't rebalanced.
 func (w *workerCG) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	msgs := &claimMessages{claim: claim.Messages()}
+	for {
+		var err error
+		handler, swapped := w.current()
+		if handler.BatchHandler != nil {
+			err = w.consumeBatch(sess, claim, msgs, handler, swapped)
+		} else if handler.Concurrency > 1 && len(handler.Handlers) != 0 {
+			err = w.consumeConcurrent(sess, msgs, handler.Concurrency, swapped)
+		} else {
+			err = w.consumeSingle(sess, msgs, swapped)
+		}
+		if err != errSwapped {
+			return err
+		}
+	}
+}
+
+// claimMessages are messages of a claim, the ones left by a swapped mode go
+// first
+type claimMessages struct {
+	claim <-chan *sarama.ConsumerMessage
+	left  []*sarama.ConsumerMessage
+}
+
+// next delivers the next message, a received one must be passed to took
+func (msgs *claimMessages) next() <-chan *sarama.ConsumerMessage {
+	if len(msgs.left) == 0 {
+		return msgs.claim
+	}
+	ch := make(chan *sarama.ConsumerMessage, 1)
+	ch <- msgs.left[0]
+	return ch
+}
+
+func (msgs *claimMessages) took() {
+	if len(msgs.left) != 0 {
+		msgs.left = msgs.left[1:]
+	}
+}
+
+// leave puts unhandled messages back for the next mode
+func (msgs *claimMessages) leave(left ...*sarama.ConsumerMessage) {
+	msgs.left = append(append([]*sarama.ConsumerMessage{}, left...), msgs.left...)
+}
+
+func (w *workerCG) consumeSingle(sess sarama.ConsumerGroupSession, msgs *claimMessages,
+	swapped <-chan struct{}) error {
+
+	ctx, cancel := modeContext(sess, swapped)
+	defer cancel()
 	for {
 		select {
-		case msg, ok := <-claim.Messages():
+		case msg, ok := <-msgs.next():
 			if !ok {
 				return nil
 			}
-			cgmsg := &ConsumerGroupMessage{
-				Topic:         msg.Topic,
-				Partition:     msg.Partition,
-				Offset:        msg.Offset,
-				Payload:       msg.Value,
-				ConsumerGroup: w.group,
-			}
-			if w.handlers != nil {
-				for _, handler := range w.handlers {
+			msgs.took()
+			cgmsg := w.message(ctx, msg)
+			for {
+				handler, current := w.current()
+				if current != swapped {
+					msgs.leave(msg)
+					return errSwapped
+				}
+				if len(handler.Handlers) == 0 {
+					w.cg.outputCh <- cgmsg
+					break
+				}
+				cgmsg.Error = nil
+				for _, handler := range handler.Handlers {
 					handler(cgmsg)
 				}
-			} else {
-				w.cg.outputCh <- cgmsg
+				if cgmsg.Error == nil {
+					break
+				}
+				if err := w.retry(sess, swapped); err != nil {
+					msgs.leave(msg)
+					return err
+				}
 			}
 			sess.MarkMessage(msg, "synced")
 
+		case <-swapped:
+			return errSwapped
+
 		case <-sess.Context().Done():
 			return sess.Context().Err()
 		}
 	}
-	return nil
+}
+
+// retry waits the backoff, errSwapped or the error of the session if the
+// claim should end meanwhile
+func (w *workerCG) retry(sess sarama.ConsumerGroupSession, swapped <-chan struct{}) error {
+	timer := time.NewTimer(w.backoff)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-swapped:
+		return errSwapped
+	case <-sess.Context().Done():
+		return sess.Context().Err()
+	}
+}
+
+// modeContext is canceled when the session ends or the mode is swapped
+func modeContext(sess sarama.ConsumerGroupSession,
+	swapped <-chan struct{}) (context.Context, context.CancelFunc) {
+
+	ctx, cancel := context.WithCancel(sess.Context())
+	go func() {
+		select {
+		case <-swapped:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+func (w *workerCG) message(ctx context.Context, msg *sarama.ConsumerMessage) *ConsumerGroupMessage {
+	return &ConsumerGroupMessage{
+		Topic:         msg.Topic,
+		Partition:     msg.Partition,
+		Offset:        msg.Offset,
+		Key:           msg.Key,
+		Headers:       msg.Headers,
+		Timestamp:     msg.Timestamp,
+		Payload:       msg.Value,
+		ConsumerGroup: w.group,
+		Context:       ctx,
+	}
+}
+
+// a pending batch is dropped if the claim ends, it will be consumed again
+// by the next owner
+func (w *workerCG) consumeBatch(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim,
+	msgs *claimMessages, handler *ConsumerGroupHandler, swapped <-chan struct{}) error {
+
+	var (
+		batched []*sarama.ConsumerMessage
+		timer   *time.Timer
+		expire  <-chan time.Time
+	)
+	ctx, cancel := modeContext(sess, swapped)
+	defer cancel()
+	// the pending batch goes to the next mode
+	leave := func() error {
+		if timer != nil {
+			timer.Stop()
+		}
+		msgs.leave(batched...)
+		return errSwapped
+	}
+	flush := func() error {
+		if timer != nil {
+			timer.Stop()
+			timer, expire = nil, nil
+		}
+		if len(batched) == 0 {
+			return nil
+		}
+		batch := &ConsumerGroupBatch{
+			Topic:         claim.Topic(),
+			ConsumerGroup: w.group,
+			Partition:     claim.Partition(),
+			Messages:      make([]*ConsumerGroupMessage, 0, len(batched)),
+			Context:       ctx,
+		}
+		for _, msg := range batched {
+			batch.Messages = append(batch.Messages, w.message(ctx, msg))
+		}
+		for {
+			current, currentSwapped := w.current()
+			if currentSwapped != swapped {
+				return leave()
+			}
+			batch.Error = nil
+			current.BatchHandler(batch)
+			if batch.Error == nil {
+				break
+			}
+			if err := w.retry(sess, swapped); err != nil {
+				if err == errSwapped {
+					return leave()
+				}
+				return err
+			}
+		}
+		// marking the last one covers the whole batch
+		sess.MarkMessage(batched[len(batched)-1], "synced")
+		batched = nil
+		return nil
+	}
+	for {
+		select {
+		case msg, ok := <-msgs.next():
+			if !ok {
+				return nil
+			}
+			msgs.took()
+			batched = append(batched, msg)
+			if len(batched) >= handler.Batch {
+				if err := flush(); err != nil {
+					return err
+				}
+				continue
+			}
+			if timer == nil {
+				timer = time.NewTimer(handler.Window)
+				expire = timer.C
+			}
+
+		case <-expire:
+			timer, expire = nil, nil
+			if err := flush(); err != nil {
+				return err
+			}
+
+		case <-swapped:
+			return leave()
+
+		case <-sess.Context().Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return sess.Context().Err()
+		}
+	}
+}
+
+type laneMessage struct {
+	msg   *sarama.ConsumerMessage
+	cgmsg *ConsumerGroupMessage
+	// set by the lane
+	handled bool
+	done    bool
+}
+
+// messages not started are skipped once the claim ends, they will be
+// consumed again by the next owner, or by the next mode if swapped
+func (w *workerCG) consumeConcurrent(sess sarama.ConsumerGroupSession, msgs *claimMessages,
+	concurrency int, swapped <-chan struct{}) error {
+
+	ctx, cancel := modeContext(sess, swapped)
+	defer cancel()
+	inflight := concurrency * defaultLaneQueue
+	results := make(chan *laneMessage, inflight)
+	lanes := make([]chan *laneMessage, concurrency)
+	stopped := int32(0)
+	wg := sync.WaitGroup{}
+	for i := range lanes {
+		lanes[i] = make(chan *laneMessage, inflight)
+		wg.Add(1)
+		go func(lane <-chan *laneMessage) {
+			defer wg.Done()
+			for lm := range lane {
+				for atomic.LoadInt32(&stopped) == 0 {
+					current, currentSwapped := w.current()
+					if currentSwapped != swapped {
+						lm.cgmsg.Error = errSwapped
+						break
+					}
+					lm.cgmsg.Error = nil
+					for _, handler := range current.Handlers {
+						handler(lm.cgmsg)
+					}
+					if lm.cgmsg.Error == nil {
+						lm.handled = true
+						break
+					}
+					// the lane waits, later messages of the key stay in order
+					if err := w.retry(sess, swapped); err != nil {
+						lm.cgmsg.Error = err
+						break
+					}
+				}
+				results <- lm
+			}
+		}(lanes[i])
+	}
+	stop := func() {
+		atomic.StoreInt32(&stopped, 1)
+		for _, lane := range lanes {
+			close(lane)
+		}
+		wg.Wait()
+	}
+
+	// in offset order
+	pending := []*laneMessage{}
+	closed := false
+	// marks up to the lowest unfinished message
+	mark := func() {
+		var last *laneMessage
+		for len(pending) > 0 && pending[0].done {
+			last = pending[0]
+			pending = pending[1:]
+		}
+		if last != nil {
+			sess.MarkMessage(last.msg, "synced")
+		}
+	}
+	// lanes finish what they're handling, the unhandled messages go to the
+	// next mode
+	leave := func() error {
+		stop()
+		for _, lm := range pending {
+			lm.done = lm.handled
+		}
+		mark()
+		left := []*sarama.ConsumerMessage{}
+		for _, lm := range pending {
+			if !lm.done {
+				left = append(left, lm.msg)
+			}
+		}
+		msgs.leave(left...)
+		return errSwapped
+	}
+	for {
+		var in <-chan *sarama.ConsumerMessage
+		if !closed && len(pending) < inflight {
+			in = msgs.next()
+		}
+		if closed && len(pending) == 0 {
+			stop()
+			return nil
+		}
+		select {
+		case msg, ok := <-in:
+			if !ok {
+				// finish the pending ones before the claim ends
+				closed = true
+				continue
+			}
+			msgs.took()
+			lm := &laneMessage{msg: msg, cgmsg: w.message(ctx, msg)}
+			pending = append(pending, lm)
+			lanes[laneOf(msg, len(lanes))] <- lm
+
+		case lm := <-results:
+			if lm.cgmsg.Error == errSwapped {
+				return leave()
+			}
+			if lm.cgmsg.Error != nil {
+				// the session ends, unmarked
+				stop()
+				return lm.cgmsg.Error
+			}
+			lm.done = true
+			mark()
+
+		case <-swapped:
+			return leave()
+
+		case <-sess.Context().Done():
+			stop()
+			return sess.Context().Err()
+		}
+	}
+}
+
+// messages without a key are spread by offset
+func laneOf(msg *sarama.ConsumerMessage, lanes int) int {
+	if len(msg.Key) == 0 {
+		return int(msg.Offset % int64(lanes))
+	}
+	h := fnv.New32a()
+	h.Write(msg.Key)
+	return int(h.Sum32() % uint32(lanes))
 }
 
 func (w *workerCG) fini() {
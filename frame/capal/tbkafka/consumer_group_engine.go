@@ -0,0 +1,60 @@
+package tbkafka
+
+import (
+	"time"
+
+	"github.com/jumboframes/tigerbalm/frame/engine"
+)
+
+type CGMessage struct {
+	Topic     string
+	Group     string
+	Partition int32
+	Offset    int64
+	Key       string
+	// the last one wins if a header repeats
+	Headers map[string]string
+	// unix milliseconds, zero if the broker doesn't support
+	Timestamp int64
+	Payload   string
+	// base64 if Payload is encoded for binary safety
+	Encoding string
+}
+
+func CGMessage2TbCGMessage(cgmsg *ConsumerGroupMessage) (*CGMessage, error) {
+	headers := make(map[string]string, len(cgmsg.Headers))
+	for _, header := range cgmsg.Headers {
+		if header != nil {
+			headers[string(header.Key)] = string(header.Value)
+		}
+	}
+	timestamp := int64(0)
+	if !cgmsg.Timestamp.IsZero() && cgmsg.Timestamp.Unix() > 0 {
+		timestamp = cgmsg.Timestamp.UnixNano() / int64(time.Millisecond)
+	}
+	return &CGMessage{
+		Topic:     cgmsg.Topic,
+		Group:     cgmsg.ConsumerGroup,
+		Partition: cgmsg.Partition,
+		Offset:    cgmsg.Offset,
+		Key:       string(cgmsg.Key),
+		Headers:   headers,
+		Timestamp: timestamp,
+		Payload:   string(cgmsg.Payload),
+	}, nil
+}
+
+// EncodePayload encodes Payload for binary safety
+func (msg *CGMessage) EncodePayload(encoding string) error {
+	payload, err := engine.Encode([]byte(msg.Payload), encoding)
+	if err != nil {
+		return err
+	}
+	msg.Payload = payload
+	msg.Encoding = encoding
+	return nil
+}
+
+func TbMessage2Value(vm engine.VM, msg *CGMessage) (engine.Value, error) {
+	return vm.ToValue(msg)
+}
@@ -1,9 +1,16 @@
 package tbkafka
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"os/signal"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
 )
 
 func TestConsumerGroup(t *testing.T) {
@@ -33,3 +40,267 @@ func TestConsumerGroup(t *testing.T) {
 	}
 QUIT:
 }
+
+type testSession struct {
+	sarama.ConsumerGroupSession
+	ctx    context.Context
+	marked []int64
+	onMark func(offset int64) // optional
+}
+
+func (sess *testSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	if sess.onMark != nil {
+		sess.onMark(msg.Offset)
+	}
+	sess.marked = append(sess.marked, msg.Offset)
+}
+
+func (sess *testSession) Context() context.Context {
+	return sess.ctx
+}
+
+type testClaim struct {
+	sarama.ConsumerGroupClaim
+	msgs chan *sarama.ConsumerMessage
+}
+
+func (claim *testClaim) Topic() string { return "foo" }
+
+func (claim *testClaim) Partition() int32 { return 0 }
+
+func (claim *testClaim) Messages() <-chan *sarama.ConsumerMessage { return claim.msgs }
+
+func TestConsumeBatch(t *testing.T) {
+	batches := [][]int64{}
+	w := newWorkerCG(nil, "foo", "bar", &ConsumerGroupHandler{
+		Batch:  3,
+		Window: 50 * time.Millisecond,
+	})
+	w.backoff = 10 * time.Millisecond
+	w.handler.BatchHandler = func(batch *ConsumerGroupBatch) {
+		offsets := []int64{}
+		for _, msg := range batch.Messages {
+			offsets = append(offsets, msg.Offset)
+		}
+		batches = append(batches, offsets)
+		if len(batches) == 3 {
+			batch.Error = errors.New("failed")
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sess := &testSession{ctx: ctx}
+	claim := &testClaim{msgs: make(chan *sarama.ConsumerMessage)}
+	done := make(chan error)
+	go func() {
+		done <- w.ConsumeClaim(sess, claim)
+	}()
+
+	// full batch, then a window expired batch, then a failed one handled
+	// again in the claim
+	for offset := int64(0); offset < 5; offset++ {
+		claim.msgs <- &sarama.ConsumerMessage{Topic: "foo", Offset: offset}
+	}
+	time.Sleep(100 * time.Millisecond)
+	claim.msgs <- &sarama.ConsumerMessage{Topic: "foo", Offset: 5}
+	time.Sleep(100 * time.Millisecond)
+	close(claim.msgs)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+	if fmt.Sprint(batches) != "[[0 1 2] [3 4] [5] [5]]" {
+		t.Errorf("unexpected batches: %v", batches)
+	}
+	if fmt.Sprint(sess.marked) != "[2 4 5]" {
+		t.Errorf("unexpected marked: %v", sess.marked)
+	}
+}
+
+func TestConsumeRetry(t *testing.T) {
+	handled := []int64{}
+	failures := 2
+	var msgCtx context.Context
+	w := newWorkerCG(nil, "foo", "bar", &ConsumerGroupHandler{})
+	w.backoff = 10 * time.Millisecond
+	w.handler.Handlers = []func(*ConsumerGroupMessage){func(msg *ConsumerGroupMessage) {
+		handled = append(handled, msg.Offset)
+		msgCtx = msg.Context
+		if msg.Offset == 1 && failures > 0 {
+			failures--
+			msg.Error = errors.New("failed")
+		}
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sess := &testSession{ctx: ctx}
+	claim := &testClaim{msgs: make(chan *sarama.ConsumerMessage)}
+	done := make(chan error)
+	go func() {
+		done <- w.ConsumeClaim(sess, claim)
+	}()
+
+	// the claim goes on after the failed message is handled again
+	for offset := int64(0); offset < 3; offset++ {
+		claim.msgs <- &sarama.ConsumerMessage{Topic: "foo", Offset: offset}
+	}
+	close(claim.msgs)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+	if fmt.Sprint(handled) != "[0 1 1 1 2]" {
+		t.Errorf("unexpected handled: %v", handled)
+	}
+	if fmt.Sprint(sess.marked) != "[0 1 2]" {
+		t.Errorf("unexpected marked: %v", sess.marked)
+	}
+
+	// unmarked if the session ends while failing
+	failures = 10
+	handled, sess.marked = nil, nil
+	claim = &testClaim{msgs: make(chan *sarama.ConsumerMessage)}
+	go func() {
+		done <- w.ConsumeClaim(sess, claim)
+	}()
+	claim.msgs <- &sarama.ConsumerMessage{Topic: "foo", Offset: 1}
+	time.Sleep(30 * time.Millisecond)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("unexpected err: %v", err)
+	}
+	if len(sess.marked) != 0 {
+		t.Errorf("unexpected marked: %v", sess.marked)
+	}
+	// handlers backing off are told the session ended
+	if msgCtx == nil || msgCtx.Err() == nil {
+		t.Error("unexpected message context not done")
+	}
+}
+
+func TestConsumeConcurrent(t *testing.T) {
+	mu := sync.Mutex{}
+	handled := map[string][]int64{}
+	w := newWorkerCG(nil, "foo", "bar", &ConsumerGroupHandler{Concurrency: 4})
+	w.handler.Handlers = []func(*ConsumerGroupMessage){func(msg *ConsumerGroupMessage) {
+		// slow key finishes after later offsets
+		if string(msg.Key) == "slow" {
+			time.Sleep(20 * time.Millisecond)
+		}
+		mu.Lock()
+		handled[string(msg.Key)] = append(handled[string(msg.Key)], msg.Offset)
+		mu.Unlock()
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sess := &testSession{ctx: ctx}
+	sess.onMark = func(offset int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		count := 0
+		for _, offsets := range handled {
+			for _, handledOffset := range offsets {
+				if handledOffset <= offset {
+					count++
+				}
+			}
+		}
+		if int64(count) != offset+1 {
+			t.Errorf("marked %d beyond unfinished: %v", offset, handled)
+		}
+	}
+	claim := &testClaim{msgs: make(chan *sarama.ConsumerMessage)}
+	done := make(chan error)
+	go func() {
+		done <- w.ConsumeClaim(sess, claim)
+	}()
+
+	keys := []string{"slow", "fast", "slow", "fast", "fast", "slow"}
+	for offset, key := range keys {
+		claim.msgs <- &sarama.ConsumerMessage{Topic: "foo", Key: []byte(key), Offset: int64(offset)}
+	}
+	close(claim.msgs)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+	if fmt.Sprint(handled["slow"]) != "[0 2 5]" || fmt.Sprint(handled["fast"]) != "[1 3 4]" {
+		t.Errorf("unexpected order: %v", handled)
+	}
+	for i := 1; i < len(sess.marked); i++ {
+		if sess.marked[i] <= sess.marked[i-1] {
+			t.Errorf("marked out of order: %v", sess.marked)
+		}
+	}
+	if len(sess.marked) == 0 || sess.marked[len(sess.marked)-1] != 5 {
+		t.Errorf("unexpected marked: %v", sess.marked)
+	}
+}
+
+func TestSwap(t *testing.T) {
+	handled := make(chan string, 10)
+	handler := func(name string) func(*ConsumerGroupMessage) {
+		return func(msg *ConsumerGroupMessage) {
+			handled <- fmt.Sprintf("%s%d", name, msg.Offset)
+		}
+	}
+	w := newWorkerCG(nil, "foo", "bar", &ConsumerGroupHandler{
+		Handlers: []func(*ConsumerGroupMessage){handler("a")},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sess := &testSession{ctx: ctx}
+	claim := &testClaim{msgs: make(chan *sarama.ConsumerMessage)}
+	done := make(chan error)
+	go func() {
+		done <- w.ConsumeClaim(sess, claim)
+	}()
+
+	// the claim goes on with the new handler
+	claim.msgs <- &sarama.ConsumerMessage{Topic: "foo", Offset: 0}
+	w.swap(&ConsumerGroupHandler{
+		Handlers: []func(*ConsumerGroupMessage){handler("b")},
+	})
+	claim.msgs <- &sarama.ConsumerMessage{Topic: "foo", Offset: 1}
+	if a, b := <-handled, <-handled; a != "a0" || b != "b1" {
+		t.Errorf("unexpected handled: %s %s", a, b)
+	}
+
+	// the claim goes on in batch mode, then back in concurrent mode
+	batches := make(chan string, 10)
+	w.swap(&ConsumerGroupHandler{
+		BatchHandler: func(batch *ConsumerGroupBatch) {
+			offsets := []int64{}
+			for _, msg := range batch.Messages {
+				offsets = append(offsets, msg.Offset)
+			}
+			batches <- fmt.Sprint(offsets)
+		},
+		Batch:  2,
+		Window: time.Second,
+	})
+	claim.msgs <- &sarama.ConsumerMessage{Topic: "foo", Offset: 2}
+	claim.msgs <- &sarama.ConsumerMessage{Topic: "foo", Offset: 3}
+	if batch := <-batches; batch != "[2 3]" {
+		t.Errorf("unexpected batch: %s", batch)
+	}
+	// the pending batch goes to the new mode
+	claim.msgs <- &sarama.ConsumerMessage{Topic: "foo", Offset: 4}
+	w.swap(&ConsumerGroupHandler{
+		Handlers:    []func(*ConsumerGroupMessage){handler("c")},
+		Concurrency: 2,
+	})
+	claim.msgs <- &sarama.ConsumerMessage{Topic: "foo", Offset: 5}
+	if c, d := <-handled, <-handled; c+d != "c4c5" && c+d != "c5c4" {
+		t.Errorf("unexpected handled: %s %s", c, d)
+	}
+	close(claim.msgs)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Error("claim not finished")
+	}
+	if fmt.Sprint(sess.marked) != "[0 1 3 5]" && fmt.Sprint(sess.marked) != "[0 1 3 4 5]" {
+		t.Errorf("unexpected marked: %v", sess.marked)
+	}
+}
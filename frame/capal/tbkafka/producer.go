@@ -23,6 +23,8 @@ const (
 	CompressionNone   CompressionType = 0
 	CompressionGZIP   CompressionType = 1
 	CompressionSnappy CompressionType = 2
+	CompressionLZ4    CompressionType = 3
+	CompressionZSTD   CompressionType = 4 // kafka 2.1.0 and later
 )
 
 const (
@@ -30,6 +32,10 @@ const (
 	defaultTopicConcu = 10
 )
 
+// PartitionAuto leaves the partition to the partitioner when
+// OptionMixedPartition was set
+const PartitionAuto int32 = -1
+
 type ProducerOption func(*Producer) error
 
 //用户消息
@@ -97,7 +103,10 @@ func NewProducer(addrs []string, options ...ProducerOption) (*Producer, error) {
 		wg:     new(sync.WaitGroup),
 	}
 	for _, option := range options {
-		option(p)
+		err := option(p)
+		if err != nil {
+			return nil, err
+		}
 	}
 	p.inputCh = make(chan *ProducerMessage, p.queue)
 	if !p.unshare {
@@ -122,25 +131,36 @@ func (p *Producer) Input() chan<- *ProducerMessage {
 	return p.inputCh
 }
 
+// Fini flushes messages queued before closing, Input mustn't be used
+// after Fini.
 func (p *Producer) Fini() {
+	// no more dispatching
+	close(p.inputCh)
+	p.wg.Wait()
+
+	p.tps.Range(func(key, value interface{}) bool {
+		tp := value.(*topicParamsProducer)
+		close(tp.topicInputCh)
+		if tp.w != nil {
+			tp.w.finiInput()
+		}
+		if p.unshare && tp.asyncP != nil {
+			// Close waits for buffered messages to be flushed
+			tp.asyncP.Close()
+		}
+		return true
+	})
 	if !p.unshare {
 		p.asyncP.Close()
 	}
 
 	p.tps.Range(func(key, value interface{}) bool {
 		tp := value.(*topicParamsProducer)
-		if p.unshare {
-			tp.asyncP.Close()
+		if tp.w != nil {
+			tp.w.fini()
 		}
-
-		close(tp.topicInputCh)
-		tp.w.fini()
-
 		return true
 	})
-
-	close(p.inputCh)
-	p.wg.Wait()
 	return
 }
 
@@ -274,6 +294,13 @@ func OptionSasl(user, passwd string) ProducerOption {
 	}
 }
 
+// plain, scram-sha-256 or scram-sha-512
+func OptionSaslMechanism(mechanism, user, passwd string) ProducerOption {
+	return func(p *Producer) error {
+		return setSasl(p.config, mechanism, user, passwd)
+	}
+}
+
 func OptionFlushFrequency(frequency time.Duration) ProducerOption {
 	return func(p *Producer) error {
 		p.config.Producer.Flush.Frequency = frequency
@@ -332,6 +359,35 @@ func OptionManualPartition() ProducerOption {
 	}
 }
 
+// use the partition of the message if it's not PartitionAuto, otherwise
+// hash the key
+func OptionMixedPartition() ProducerOption {
+	return func(p *Producer) error {
+		p.config.Producer.Partitioner = newMixedPartitioner
+		return nil
+	}
+}
+
+type mixedPartitioner struct {
+	hash sarama.Partitioner
+}
+
+func newMixedPartitioner(topic string) sarama.Partitioner {
+	return &mixedPartitioner{sarama.NewHashPartitioner(topic)}
+}
+
+func (mp *mixedPartitioner) Partition(msg *sarama.ProducerMessage,
+	numPartitions int32) (int32, error) {
+	if msg.Partition != PartitionAuto {
+		return msg.Partition, nil
+	}
+	return mp.hash.Partition(msg, numPartitions)
+}
+
+func (mp *mixedPartitioner) RequiresConsistency() bool {
+	return true
+}
+
 func OptionMaxMessageBytes(size int) ProducerOption {
 	return func(p *Producer) error {
 		p.config.Producer.MaxMessageBytes = size
@@ -348,6 +404,10 @@ func OptionCompression(compressionType CompressionType) ProducerOption {
 			p.config.Producer.Compression = sarama.CompressionGZIP
 		case CompressionSnappy:
 			p.config.Producer.Compression = sarama.CompressionSnappy
+		case CompressionLZ4:
+			p.config.Producer.Compression = sarama.CompressionLZ4
+		case CompressionZSTD:
+			p.config.Producer.Compression = sarama.CompressionZSTD
 		default:
 			return errors.New("no such type")
 		}
@@ -372,21 +432,23 @@ func OptionVersion(version sarama.KafkaVersion) ProducerOption {
 }
 
 type workerP struct {
-	wg *sync.WaitGroup
+	inWg *sync.WaitGroup // inputs
+	wg   *sync.WaitGroup // results
 }
 
 func newworkerP() *workerP {
 	w := &workerP{
-		wg: new(sync.WaitGroup),
+		inWg: new(sync.WaitGroup),
+		wg:   new(sync.WaitGroup),
 	}
 	return w
 }
 
 func (w *workerP) work(inCh <-chan *ProducerMessage, fCh, sCh chan<- *ProducerMessage, asyncP sarama.AsyncProducer, concu uint32) {
-	w.wg.Add(int(concu))
+	w.inWg.Add(int(concu))
 	for i := 0; i < int(concu); i++ {
 		go func() {
-			defer w.wg.Done()
+			defer w.inWg.Done()
 			for msg := range inCh {
 				saramaMsg := &sarama.ProducerMessage{
 					Topic:     msg.Topic,
@@ -443,6 +505,11 @@ func (w *workerP) work(inCh <-chan *ProducerMessage, fCh, sCh chan<- *ProducerMe
 	}
 }
 
+// finiInput returns after all inputs are sent to the async producer
+func (w *workerP) finiInput() {
+	w.inWg.Wait()
+}
+
 func (w *workerP) fini() {
 	w.wg.Wait()
 }
@@ -0,0 +1,269 @@
+package tbkafka
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/jumboframes/tigerbalm"
+	"github.com/jumboframes/tigerbalm/frame/engine"
+)
+
+const (
+	defaultSyncTimeout = 10 * time.Second
+	defaultResultQueue = 1024
+)
+
+var (
+	ErrProduceTimeout = errors.New("produce timeout")
+	ErrProducerClosed = errors.New("producer closed")
+)
+
+// TbProducer is goroutine safe, it can be shared by plugins.
+type TbProducer struct {
+	p         *Producer
+	succeedCh chan *ProducerMessage
+	failedCh  chan *ProducerMessage
+
+	// a handler still running may produce after closing
+	mu     sync.RWMutex
+	closed bool
+}
+
+func NewTbProducer(brokers []string, options ...ProducerOption) (*TbProducer, error) {
+	succeedCh := make(chan *ProducerMessage, defaultResultQueue)
+	failedCh := make(chan *ProducerMessage, defaultResultQueue)
+	options = append(options,
+		OptionMixedPartition(),
+		OptionSucceedCh(succeedCh),
+		OptionFailedCh(failedCh))
+	producer, err := NewProducer(brokers, options...)
+	if err != nil {
+		return nil, err
+	}
+	go report(succeedCh)
+	go report(failedCh)
+	return &TbProducer{
+		p:         producer,
+		succeedCh: succeedCh,
+		failedCh:  failedCh,
+	}, nil
+}
+
+func NewTbProducerFromConf() (*TbProducer, error) {
+	options, err := ProducerOptionsFromConf()
+	if err != nil {
+		return nil, err
+	}
+	return NewTbProducer(tigerbalm.Conf.Kafka.Brokers, options...)
+}
+
+// Fini flushes messages queued, then closes the producer
+func (tbproducer *TbProducer) Fini() {
+	tbproducer.mu.Lock()
+	defer tbproducer.mu.Unlock()
+	if tbproducer.closed {
+		return
+	}
+	tbproducer.closed = true
+	tbproducer.p.Fini()
+	close(tbproducer.succeedCh)
+	close(tbproducer.failedCh)
+}
+
+// input returns false if the producer is closed
+func (tbproducer *TbProducer) input(msg *ProducerMessage) bool {
+	tbproducer.mu.RLock()
+	defer tbproducer.mu.RUnlock()
+	if tbproducer.closed {
+		return false
+	}
+	tbproducer.p.Input() <- msg
+	return true
+}
+
+// report sends results to sync produces waiting, results of async ones
+// are dropped
+func report(ch <-chan *ProducerMessage) {
+	for msg := range ch {
+		if resultCh, ok := msg.Custom.(chan *ProducerMessage); ok {
+			resultCh <- msg
+		}
+	}
+}
+
+func (tbproducer *TbProducer) Object() engine.Object {
+	return engine.Object{
+		"Produce":     tbproducer.Produce,
+		"ProduceSync": tbproducer.ProduceSync,
+	}
+}
+
+// Produce returns true once the message is queued
+func (tbproducer *TbProducer) Produce(call engine.FunctionCall) engine.Value {
+	argc := len(call.ArgumentList)
+	if argc != 1 {
+		return boolValue(call.VM, false)
+	}
+	msg, err := Value2PMessage(call.ArgumentList[0])
+	if err != nil {
+		return boolValue(call.VM, false)
+	}
+
+	return boolValue(call.VM, tbproducer.input(msg))
+}
+
+/*
+ProduceSync waits for the delivery and returns:
+{
+	"Partition": 0,
+	"Offset": 1024
+}
+or
+{
+	"Error": "kafka: ..."
+}
+*/
+func (tbproducer *TbProducer) ProduceSync(call engine.FunctionCall) engine.Value {
+	argc := len(call.ArgumentList)
+	if argc != 1 {
+		return resultValue(call.VM, nil, errors.New("args != 1"))
+	}
+	msg, err := Value2PMessage(call.ArgumentList[0])
+	if err != nil {
+		return resultValue(call.VM, nil, err)
+	}
+	result, err := tbproducer.Send(msg)
+	return resultValue(call.VM, result, err)
+}
+
+// Send waits for the delivery, the result carries partition and offset
+func (tbproducer *TbProducer) Send(msg *ProducerMessage) (*ProducerMessage, error) {
+	// buffered, the reporter never blocks even if the waiting timed out
+	resultCh := make(chan *ProducerMessage, 1)
+	msg.Custom = resultCh
+	if !tbproducer.input(msg) {
+		return nil, ErrProducerClosed
+	}
+
+	timer := time.NewTimer(defaultSyncTimeout)
+	defer timer.Stop()
+	select {
+	case result := <-resultCh:
+		return result, result.Error
+	case <-timer.C:
+		return nil, ErrProduceTimeout
+	}
+}
+
+func resultValue(vm engine.VM, msg *ProducerMessage, err error) engine.Value {
+	result := map[string]interface{}{}
+	if err != nil {
+		result["Error"] = err.Error()
+	} else if msg != nil {
+		result["Partition"] = msg.Partition
+		result["Offset"] = msg.Offset
+	}
+	value, err := vm.ToValue(result)
+	if err != nil {
+		return vm.Null()
+	}
+	return value
+}
+
+func boolValue(vm engine.VM, b bool) engine.Value {
+	value, err := vm.ToValue(b)
+	if err != nil {
+		return vm.Undefined()
+	}
+	return value
+}
+
+/*
+{
+	"Topic": "foo",
+	"Payload": "bar",
+	"Key": "baz",
+	"Headers": {"trace-id": "abc"},
+	"Partition": 0
+}
+Key, Headers and Partition are optional, without Partition the key is hashed
+*/
+func Value2PMessage(msg engine.Value) (*ProducerMessage, error) {
+	// topic
+	value, err := msg.Get("Topic")
+	if err != nil {
+		return nil, err
+	}
+	topic, err := value.ToString()
+	if err != nil {
+		return nil, err
+	}
+	// payload
+	value, err = msg.Get("Payload")
+	if err != nil {
+		return nil, err
+	}
+	payload, err := value.ToString()
+	if err != nil {
+		return nil, err
+	}
+	encoding, err := engine.GetEncoding(msg)
+	if err != nil {
+		return nil, err
+	}
+	data, err := engine.Decode(payload, encoding)
+	if err != nil {
+		return nil, err
+	}
+	pmsg := &ProducerMessage{
+		Topic:     topic,
+		Payload:   data,
+		Partition: PartitionAuto,
+	}
+	// key
+	value, err = msg.Get("Key")
+	if err != nil {
+		return nil, err
+	}
+	if value.IsDefined() && !value.IsNull() {
+		key, err := value.ToString()
+		if err != nil {
+			return nil, err
+		}
+		pmsg.Key = sarama.StringEncoder(key)
+	}
+	// headers
+	value, err = msg.Get("Headers")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range value.Keys() {
+		header, err := value.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		str, err := header.ToString()
+		if err != nil {
+			return nil, err
+		}
+		pmsg.Headers = append(pmsg.Headers, sarama.RecordHeader{
+			Key:   []byte(name),
+			Value: []byte(str),
+		})
+	}
+	// partition
+	value, err = msg.Get("Partition")
+	if err != nil {
+		return nil, err
+	}
+	if value.IsDefined() && !value.IsNull() {
+		partition, err := value.ToInteger()
+		if err != nil {
+			return nil, err
+		}
+		pmsg.Partition = int32(partition)
+	}
+	return pmsg, nil
+}
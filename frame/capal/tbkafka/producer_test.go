@@ -0,0 +1,95 @@
+package tbkafka
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/jumboframes/tigerbalm"
+	"github.com/jumboframes/tigerbalm/frame/engine"
+)
+
+func TestTbProducer(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("foo", 0, broker.BrokerID()).
+			SetLeader("foo", 1, broker.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t).SetVersion(3).
+			SetError("foo", 0, sarama.ErrMessageSizeTooLarge),
+	})
+	producers := NewProducers()
+	defer producers.Fini()
+	producer, err := producers.Get("foo", []string{broker.Addr()})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	shared, _ := producers.Get("bar", []string{broker.Addr()})
+	if shared != producer {
+		t.Error("producer not shared")
+	}
+	for _, name := range []string{engine.EngineOtto, engine.EngineGoja} {
+		eng, _ := engine.New(name)
+		program, err := eng.Compile(name+".js", []byte(`
+			function run() {
+				var msg = {"Topic": "foo", "Payload": "bar", "Key": "baz", "Headers": {"trace-id": "abc"}}
+				msg.Partition = 1
+				var ok = producer.ProduceSync(msg)
+				msg.Partition = 0
+				var failed = producer.ProduceSync(msg)
+				return ok.Partition + "|" + ok.Error + "|" + (failed.Error != null)
+			}`))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		vm := eng.NewVM()
+		vm.Set("producer", producer.Object())
+		err = vm.Run(program)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		run, _ := vm.Get("run")
+		value, err := vm.Call(run)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if value.String() != "1|undefined|true" {
+			t.Errorf("%s: unexpected value: %s", name, value.String())
+		}
+	}
+
+	// closed by the last owner
+	producers.Release("foo")
+	if !producer.input(&ProducerMessage{Topic: "foo", Partition: 1}) {
+		t.Error("producer closed with an owner")
+	}
+	producers.Release("bar")
+	if producer.input(&ProducerMessage{Topic: "foo", Partition: 1}) {
+		t.Error("producer not closed")
+	}
+}
+
+func TestProducerOptionErr(t *testing.T) {
+	// a misspelled mechanism fails instead of falling back to plain
+	_, err := NewProducer([]string{"127.0.0.1:9092"},
+		OptionSaslMechanism("scram-sha-1", "foo", "bar"))
+	if err != errSaslMechanism {
+		t.Errorf("unexpected err: %v", err)
+	}
+
+	tigerbalm.Conf = &tigerbalm.Config{}
+	tigerbalm.Conf.Kafka.Sasl.Enable = true
+	tigerbalm.Conf.Kafka.Sasl.Mechanism = "scram-sha-1"
+	if _, err = ProducerOptionsFromConf(); err != errSaslMechanism {
+		t.Errorf("unexpected err: %v", err)
+	}
+	tigerbalm.Conf.Kafka.Sasl.Mechanism = "SCRAM-SHA-512"
+	if _, err = ProducerOptionsFromConf(); err != nil {
+		t.Errorf("unexpected err: %v", err)
+	}
+}
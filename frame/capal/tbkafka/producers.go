@@ -0,0 +1,82 @@
+package tbkafka
+
+import (
+	"strings"
+	"sync"
+)
+
+// Producers shares producers of the same brokers among owners, like
+// plugins, a producer is closed when its last owner releases it.
+type Producers struct {
+	mu        sync.Mutex
+	producers map[string]*sharedProducer
+}
+
+type sharedProducer struct {
+	producer *TbProducer
+	owners   map[string]struct{}
+}
+
+func NewProducers() *Producers {
+	return &Producers{
+		producers: make(map[string]*sharedProducer),
+	}
+}
+
+func (ps *Producers) Get(owner string, brokers []string,
+	options ...ProducerOption) (*TbProducer, error) {
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	key := strings.Join(brokers, ",")
+	shared, ok := ps.producers[key]
+	if !ok {
+		producer, err := NewTbProducer(brokers, options...)
+		if err != nil {
+			return nil, err
+		}
+		shared = &sharedProducer{
+			producer: producer,
+			owners:   make(map[string]struct{}),
+		}
+		ps.producers[key] = shared
+	}
+	shared.owners[owner] = struct{}{}
+	return shared.producer, nil
+}
+
+// Release closes producers no one owns, pending messages are flushed
+func (ps *Producers) Release(owner string) {
+	ps.mu.Lock()
+	closing := []*TbProducer{}
+	for key, shared := range ps.producers {
+		if _, ok := shared.owners[owner]; !ok {
+			continue
+		}
+		delete(shared.owners, owner)
+		if len(shared.owners) == 0 {
+			delete(ps.producers, key)
+			closing = append(closing, shared.producer)
+		}
+	}
+	ps.mu.Unlock()
+
+	for _, producer := range closing {
+		producer.Fini()
+	}
+}
+
+func (ps *Producers) Fini() {
+	ps.mu.Lock()
+	closing := []*TbProducer{}
+	for key, shared := range ps.producers {
+		delete(ps.producers, key)
+		closing = append(closing, shared.producer)
+	}
+	ps.mu.Unlock()
+
+	for _, producer := range closing {
+		producer.Fini()
+	}
+}
@@ -0,0 +1,105 @@
+package tbkafka
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/xdg-go/scram"
+)
+
+// sasl mechanisms
+const (
+	SaslPlain       = "plain"
+	SaslScramSha256 = "scram-sha-256"
+	SaslScramSha512 = "scram-sha-512"
+)
+
+var errSaslMechanism = errors.New("no such sasl mechanism")
+
+// checkSaslMechanism fails a misspelled mechanism before any client is made
+func checkSaslMechanism(mechanism string) error {
+	switch strings.ToLower(mechanism) {
+	case "", SaslPlain, SaslScramSha256, SaslScramSha512:
+		return nil
+	}
+	return errSaslMechanism
+}
+
+func setSasl(config *sarama.Config, mechanism, user, passwd string) error {
+	config.Net.SASL.Enable = true
+	config.Net.SASL.Handshake = true
+	config.Net.SASL.User = user
+	config.Net.SASL.Password = passwd
+	switch strings.ToLower(mechanism) {
+	case "", SaslPlain:
+		config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case SaslScramSha256:
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hash: sha256.New}
+		}
+	case SaslScramSha512:
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hash: sha512.New}
+		}
+	default:
+		return errSaslMechanism
+	}
+	return nil
+}
+
+// NewTLSConfig loads the CA to verify brokers and the client cert, all
+// files are optional.
+func NewTLSConfig(caFile, certFile, keyFile string, insecure bool) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: insecure,
+	}
+	if caFile != "" {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("no cert in ca file")
+		}
+		config.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+type scramClient struct {
+	*scram.ClientConversation
+	hash scram.HashGeneratorFcn
+}
+
+func (client *scramClient) Begin(user, passwd, authzID string) error {
+	c, err := client.hash.NewClient(user, passwd, authzID)
+	if err != nil {
+		return err
+	}
+	client.ClientConversation = c.NewConversation()
+	return nil
+}
+
+func (client *scramClient) Step(challenge string) (string, error) {
+	return client.ClientConversation.Step(challenge)
+}
+
+func (client *scramClient) Done() bool {
+	return client.ClientConversation.Done()
+}
@@ -1,99 +1,112 @@
 package tblog
 
 import (
-	"github.com/robertkrimen/otto"
+	"github.com/jumboframes/tigerbalm/frame/engine"
 )
 
-type TbLogOtto struct {
+type TbLogJS struct {
 	tblog *TbLog
 }
 
-func NewTbLogOtto(tblog *TbLog) *TbLogOtto {
-	return &TbLogOtto{tblog}
+func NewTbLogJS(tblog *TbLog) *TbLogJS {
+	return &TbLogJS{tblog}
 }
 
-func (log *TbLogOtto) Trace(call otto.FunctionCall) otto.Value {
+func (log *TbLogJS) Object() engine.Object {
+	return engine.Object{
+		"Trace":  log.Trace,
+		"Tracef": log.Tracef,
+		"Debug":  log.Debug,
+		"Debugf": log.Debugf,
+		"Info":   log.Info,
+		"Warn":   log.Warn,
+		"Error":  log.Error,
+		"Fatal":  log.Fatal,
+	}
+}
+
+func (log *TbLogJS) Trace(call engine.FunctionCall) engine.Value {
 	vs := make([]interface{}, len(call.ArgumentList))
 	for index, arg := range call.ArgumentList {
 		vs[index] = arg
 	}
 	log.tblog.Trace(vs...)
-	return otto.NullValue()
+	return call.VM.Null()
 }
 
-func (log *TbLogOtto) Tracef(call otto.FunctionCall) otto.Value {
+func (log *TbLogJS) Tracef(call engine.FunctionCall) engine.Value {
 	if len(call.ArgumentList) < 1 {
-		return otto.NullValue()
+		return call.VM.Null()
 	}
 	format, err := call.ArgumentList[0].ToString()
 	if err != nil {
-		return otto.NullValue()
+		return call.VM.Null()
 	}
 	vs := make([]interface{}, len(call.ArgumentList)-1)
 	for index, arg := range call.ArgumentList[1:] {
 		vs[index] = arg
 	}
 	log.tblog.Tracef(format, vs...)
-	return otto.NullValue()
+	return call.VM.Null()
 }
 
-func (log *TbLogOtto) Debug(call otto.FunctionCall) otto.Value {
+func (log *TbLogJS) Debug(call engine.FunctionCall) engine.Value {
 	vs := make([]interface{}, len(call.ArgumentList))
 	for index, arg := range call.ArgumentList {
 		vs[index] = arg
 	}
 	log.tblog.Debug(vs...)
-	return otto.NullValue()
+	return call.VM.Null()
 }
 
-func (log *TbLogOtto) Debugf(call otto.FunctionCall) otto.Value {
+func (log *TbLogJS) Debugf(call engine.FunctionCall) engine.Value {
 	if len(call.ArgumentList) < 1 {
-		return otto.NullValue()
+		return call.VM.Null()
 	}
 	format, err := call.ArgumentList[0].ToString()
 	if err != nil {
-		return otto.NullValue()
+		return call.VM.Null()
 	}
 	vs := make([]interface{}, len(call.ArgumentList)-1)
 	for index, arg := range call.ArgumentList[1:] {
 		vs[index] = arg
 	}
 	log.tblog.Debugf(format, vs...)
-	return otto.NullValue()
+	return call.VM.Null()
 }
 
-func (log *TbLogOtto) Info(call otto.FunctionCall) otto.Value {
+func (log *TbLogJS) Info(call engine.FunctionCall) engine.Value {
 	vs := make([]interface{}, len(call.ArgumentList))
 	for index, arg := range call.ArgumentList {
 		vs[index] = arg
 	}
 	log.tblog.Info(vs...)
-	return otto.NullValue()
+	return call.VM.Null()
 }
 
-func (log *TbLogOtto) Warn(call otto.FunctionCall) otto.Value {
+func (log *TbLogJS) Warn(call engine.FunctionCall) engine.Value {
 	vs := make([]interface{}, len(call.ArgumentList))
 	for index, arg := range call.ArgumentList {
 		vs[index] = arg
 	}
 	log.tblog.Warn(vs...)
-	return otto.NullValue()
+	return call.VM.Null()
 }
 
-func (log *TbLogOtto) Error(call otto.FunctionCall) otto.Value {
+func (log *TbLogJS) Error(call engine.FunctionCall) engine.Value {
 	vs := make([]interface{}, len(call.ArgumentList))
 	for index, arg := range call.ArgumentList {
 		vs[index] = arg
 	}
 	log.tblog.Error(vs...)
-	return otto.NullValue()
+	return call.VM.Null()
 }
 
-func (log *TbLogOtto) Fatal(call otto.FunctionCall) otto.Value {
+func (log *TbLogJS) Fatal(call engine.FunctionCall) engine.Value {
 	vs := make([]interface{}, len(call.ArgumentList))
 	for index, arg := range call.ArgumentList {
 		vs[index] = arg
 	}
 	log.tblog.Fatal(vs...)
-	return otto.NullValue()
+	return call.VM.Null()
 }
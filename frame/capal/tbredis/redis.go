@@ -1 +1,90 @@
 package tbredis
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jumboframes/tigerbalm"
+)
+
+const (
+	defaultDialTimeout = 5 * time.Second
+)
+
+type RedisOption func(*redis.Options)
+
+// Redis is a client backed by a connection pool, it's goroutine safe and
+// shared by all plugins.
+type Redis struct {
+	client *redis.Client
+}
+
+func NewRedis(addr string, options ...RedisOption) (*Redis, error) {
+	opts := &redis.Options{
+		Addr: addr,
+	}
+	for _, option := range options {
+		option(opts)
+	}
+	client := redis.NewClient(opts)
+	timeout := opts.DialTimeout
+	if timeout <= 0 {
+		timeout = defaultDialTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := client.Ping(ctx).Err()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &Redis{client}, nil
+}
+
+// NewRedisFromConf creates a client by the redis section of config
+func NewRedisFromConf() (*Redis, error) {
+	conf := tigerbalm.Conf.Redis
+	return NewRedis(conf.Addr,
+		OptionRedisAuth(conf.User, conf.Password),
+		OptionRedisDB(conf.DB),
+		OptionRedisPool(conf.Pool.Size, conf.Pool.MinIdle),
+		OptionRedisTimeout(conf.Timeout.Dial*time.Second,
+			conf.Timeout.Read*time.Second, conf.Timeout.Write*time.Second))
+}
+
+func (r *Redis) Client() *redis.Client {
+	return r.client
+}
+
+func (r *Redis) Fini() {
+	r.client.Close()
+}
+
+func OptionRedisAuth(user, passwd string) RedisOption {
+	return func(opts *redis.Options) {
+		opts.Username = user
+		opts.Password = passwd
+	}
+}
+
+func OptionRedisDB(db int) RedisOption {
+	return func(opts *redis.Options) {
+		opts.DB = db
+	}
+}
+
+func OptionRedisPool(size, minIdle int) RedisOption {
+	return func(opts *redis.Options) {
+		opts.PoolSize = size
+		opts.MinIdleConns = minIdle
+	}
+}
+
+func OptionRedisTimeout(dial, read, write time.Duration) RedisOption {
+	return func(opts *redis.Options) {
+		opts.DialTimeout = dial
+		opts.ReadTimeout = read
+		opts.WriteTimeout = write
+	}
+}
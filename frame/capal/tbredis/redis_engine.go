@@ -0,0 +1,198 @@
+package tbredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jumboframes/tigerbalm/frame/engine"
+)
+
+var (
+	ErrUnsupportedArg = errors.New("unsupported argument")
+)
+
+// TbRedis follows redis semantics, like seconds of EXPIRE and TTL, a
+// missing key or a failed command returns null.
+type TbRedis struct {
+	r *Redis
+}
+
+func NewTbRedis(r *Redis) *TbRedis {
+	return &TbRedis{r}
+}
+
+func (tbredis *TbRedis) Object() engine.Object {
+	return engine.Object{
+		// keys and strings
+		"Get":    tbredis.command("GET"),
+		"Set":    tbredis.Set,
+		"Del":    tbredis.command("DEL"),
+		"Exists": tbredis.command("EXISTS"),
+		"Incr":   tbredis.command("INCR"),
+		"IncrBy": tbredis.command("INCRBY"),
+		"Decr":   tbredis.command("DECR"),
+		"DecrBy": tbredis.command("DECRBY"),
+		"Expire": tbredis.command("EXPIRE"),
+		"TTL":    tbredis.command("TTL"),
+		// hashes
+		"HGet":    tbredis.command("HGET"),
+		"HSet":    tbredis.command("HSET"),
+		"HDel":    tbredis.command("HDEL"),
+		"HExists": tbredis.command("HEXISTS"),
+		"HIncrBy": tbredis.command("HINCRBY"),
+		"HKeys":   tbredis.command("HKEYS"),
+		"HLen":    tbredis.command("HLEN"),
+		"HGetAll": tbredis.HGetAll,
+		// lists
+		"LPush":  tbredis.command("LPUSH"),
+		"RPush":  tbredis.command("RPUSH"),
+		"LPop":   tbredis.command("LPOP"),
+		"RPop":   tbredis.command("RPOP"),
+		"LRange": tbredis.command("LRANGE"),
+		"LLen":   tbredis.command("LLEN"),
+		"LIndex": tbredis.command("LINDEX"),
+		"LTrim":  tbredis.command("LTRIM"),
+		// any command
+		"Do":       tbredis.Do,
+		"Pipeline": tbredis.Pipeline,
+	}
+}
+
+func (tbredis *TbRedis) command(name string) engine.Function {
+	return func(call engine.FunctionCall) engine.Value {
+		args, err := Values2Args(call.ArgumentList)
+		if err != nil {
+			return call.VM.Null()
+		}
+		result, err := tbredis.r.client.Do(context.Background(),
+			append([]interface{}{name}, args...)...).Result()
+		if err != nil {
+			return call.VM.Null()
+		}
+		return resultValue(call.VM, result)
+	}
+}
+
+// Set(key, value[, seconds]) returns true if it's set
+func (tbredis *TbRedis) Set(call engine.FunctionCall) engine.Value {
+	argc := len(call.ArgumentList)
+	if argc != 2 && argc != 3 {
+		return resultValue(call.VM, false)
+	}
+	args, err := Values2Args(call.ArgumentList)
+	if err != nil {
+		return resultValue(call.VM, false)
+	}
+	cmd := []interface{}{"SET", args[0], args[1]}
+	if argc == 3 {
+		cmd = append(cmd, "EX", args[2])
+	}
+	err = tbredis.r.client.Do(context.Background(), cmd...).Err()
+	return resultValue(call.VM, err == nil)
+}
+
+// HGetAll(key) returns an object of fields
+func (tbredis *TbRedis) HGetAll(call engine.FunctionCall) engine.Value {
+	args, err := Values2Args(call.ArgumentList)
+	if err != nil || len(args) != 1 {
+		return call.VM.Null()
+	}
+	fields, err := tbredis.r.client.HGetAll(context.Background(),
+		fmt.Sprint(args[0])).Result()
+	if err != nil {
+		return call.VM.Null()
+	}
+	return resultValue(call.VM, fields)
+}
+
+// Do(command, args...) runs any command
+func (tbredis *TbRedis) Do(call engine.FunctionCall) engine.Value {
+	if len(call.ArgumentList) == 0 {
+		return call.VM.Null()
+	}
+	args, err := Values2Args(call.ArgumentList)
+	if err != nil {
+		return call.VM.Null()
+	}
+	result, err := tbredis.r.client.Do(context.Background(), args...).Result()
+	if err != nil {
+		return call.VM.Null()
+	}
+	return resultValue(call.VM, result)
+}
+
+// Pipeline sends commands in one round trip, like
+// [["SET", "foo", "bar"], ["INCR", "count"]], and returns results in order,
+// like [{"Value": "OK", "Error": null}, {"Value": 1, "Error": null}]
+func (tbredis *TbRedis) Pipeline(call engine.FunctionCall) engine.Value {
+	if len(call.ArgumentList) != 1 || !call.ArgumentList[0].IsArray() {
+		return call.VM.Null()
+	}
+	cmdsValue := call.ArgumentList[0]
+	pipe := tbredis.r.client.Pipeline()
+	cmds := []*redis.Cmd{}
+	for _, key := range cmdsValue.Keys() {
+		cmdValue, err := cmdsValue.Get(key)
+		if err != nil || !cmdValue.IsArray() {
+			return call.VM.Null()
+		}
+		values := []engine.Value{}
+		for _, index := range cmdValue.Keys() {
+			value, err := cmdValue.Get(index)
+			if err != nil {
+				return call.VM.Null()
+			}
+			values = append(values, value)
+		}
+		args, err := Values2Args(values)
+		if err != nil || len(args) == 0 {
+			return call.VM.Null()
+		}
+		cmds = append(cmds, pipe.Do(context.Background(), args...))
+	}
+	// errors are reported by each command
+	pipe.Exec(context.Background())
+
+	results := make([]interface{}, len(cmds))
+	for index, cmd := range cmds {
+		result, err := cmd.Result()
+		if err == redis.Nil {
+			err = nil
+		}
+		var errStr interface{}
+		if err != nil {
+			errStr = err.Error()
+		}
+		results[index] = map[string]interface{}{
+			"Value": result,
+			"Error": errStr,
+		}
+	}
+	return resultValue(call.VM, results)
+}
+
+// only strings, numbers and booleans are allowed
+func Values2Args(values []engine.Value) ([]interface{}, error) {
+	args := make([]interface{}, len(values))
+	for index, value := range values {
+		if value.IsObject() || !value.IsDefined() || value.IsNull() {
+			return nil, ErrUnsupportedArg
+		}
+		arg, err := value.Export()
+		if err != nil {
+			return nil, err
+		}
+		args[index] = arg
+	}
+	return args, nil
+}
+
+func resultValue(vm engine.VM, result interface{}) engine.Value {
+	value, err := vm.ToValue(result)
+	if err != nil {
+		return vm.Null()
+	}
+	return value
+}
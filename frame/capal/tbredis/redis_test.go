@@ -0,0 +1,68 @@
+package tbredis
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jumboframes/tigerbalm/frame/engine"
+)
+
+func TestTbRedis(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer mr.Close()
+
+	r, err := NewRedis(mr.Addr(), OptionRedisPool(4, 1))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer r.Fini()
+
+	for _, name := range []string{engine.EngineOtto, engine.EngineGoja} {
+		mr.FlushAll()
+		eng, _ := engine.New(name)
+		program, err := eng.Compile(name+".js", []byte(`
+			function run() {
+				var out = []
+				out.push(redis.Set("foo", "bar", 60))
+				out.push(redis.Get("foo"))
+				out.push(redis.Get("missing"))
+				out.push(redis.Incr("count"))
+				out.push(redis.IncrBy("count", 2))
+				out.push(redis.TTL("foo") > 0)
+				redis.HSet("hash", "a", 1)
+				redis.HSet("hash", "b", "x")
+				out.push(redis.HGetAll("hash").b)
+				redis.RPush("list", "x", "y", "z")
+				out.push(redis.LRange("list", 0, -1).join(","))
+				var results = redis.Pipeline([["SET", "p", 1], ["INCR", "p"], ["HGET", "hash", "a"]])
+				out.push(results[1].Value + results[2].Value)
+				return out.join("|")
+			}`))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		vm := eng.NewVM()
+		vm.Set("redis", NewTbRedis(r).Object())
+		err = vm.Run(program)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		run, _ := vm.Get("run")
+		value, err := vm.Call(run)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		expected := "true|bar||1|3|true|x|x,y,z|21"
+		if value.String() != expected {
+			t.Errorf("%s: unexpected value: %s", name, value.String())
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package tbredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	KindSubscribe  = "subscribe"  // channel
+	KindPSubscribe = "psubscribe" // pattern
+	KindStream     = "stream"     // stream consumer group
+)
+
+const (
+	defaultSubscriptionQueue = 1024
+	defaultStreamCount       = 16
+	defaultStreamBlock       = 2 * time.Second
+	defaultStreamClaimIdle   = 30 * time.Second
+)
+
+var (
+	ErrSubscriptionExisted  = errors.New("subscription existed")
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	ErrUnsupportedKind      = errors.New("unsupported kind")
+)
+
+type Message struct {
+	Kind    string
+	Channel string                 // channel or stream key
+	Pattern string                 // psubscribe only
+	Group   string                 // stream only
+	ID      string                 // stream only
+	Payload string                 // pub/sub only
+	Values  map[string]interface{} // stream only
+	// set by the handler, a stream message is acked only without error
+	Error error
+}
+
+type SubscriberOption func(*Subscriber)
+
+// stream messages pending longer than idle, like failed ones or ones left
+// by consumers gone, are claimed and handled again, default 30s
+func OptionSubscriberClaimIdle(idle time.Duration) SubscriberOption {
+	return func(sub *Subscriber) {
+		sub.claimIdle = idle
+	}
+}
+
+// the consumer name in stream groups, default hostname-pid
+func OptionSubscriberConsumer(consumer string) SubscriberOption {
+	return func(sub *Subscriber) {
+		sub.consumer = consumer
+	}
+}
+
+// Subscriber dispatches pub/sub messages and stream consumer group
+// messages to handlers, one handler for one subscription.
+type Subscriber struct {
+	client    *redis.Client
+	consumer  string
+	claimIdle time.Duration
+
+	mu            sync.Mutex
+	pubsub        *redis.PubSub
+	subscriptions map[subscriptionID]*subscription
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type subscriptionID struct {
+	kind, key, group string
+}
+
+type subscription struct {
+	kind, key, group string
+	handler          func(*Message)
+	// pub/sub only
+	msgCh chan *Message
+	// stream only
+	cancel context.CancelFunc
+}
+
+func NewSubscriber(r *Redis, options ...SubscriberOption) *Subscriber {
+	host, _ := os.Hostname()
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := &Subscriber{
+		client:        r.client,
+		consumer:      fmt.Sprintf("%s-%d", host, os.Getpid()),
+		claimIdle:     defaultStreamClaimIdle,
+		subscriptions: make(map[subscriptionID]*subscription),
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+	for _, option := range options {
+		option(sub)
+	}
+	// subscribe nothing, channels are added later
+	sub.pubsub = sub.client.Subscribe(ctx)
+	sub.wg.Add(1)
+	go sub.receive()
+	return sub
+}
+
+// key is a channel, a pattern or a stream key, group is for streams only
+func (sub *Subscriber) Add(kind, key, group string, handler func(*Message)) error {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	id := subscriptionID{kind, key, group}
+	if _, ok := sub.subscriptions[id]; ok {
+		return ErrSubscriptionExisted
+	}
+	s := &subscription{
+		kind:    kind,
+		key:     key,
+		group:   group,
+		handler: handler,
+	}
+	switch kind {
+	case KindSubscribe, KindPSubscribe:
+		s.msgCh = make(chan *Message, defaultSubscriptionQueue)
+		var err error
+		if kind == KindSubscribe {
+			err = sub.pubsub.Subscribe(sub.ctx, key)
+		} else {
+			err = sub.pubsub.PSubscribe(sub.ctx, key)
+		}
+		if err != nil {
+			return err
+		}
+		sub.wg.Add(1)
+		go sub.dispatch(s)
+	case KindStream:
+		err := sub.client.XGroupCreateMkStream(sub.ctx, key, group, "$").Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return err
+		}
+		ctx, cancel := context.WithCancel(sub.ctx)
+		s.cancel = cancel
+		sub.wg.Add(1)
+		go sub.read(ctx, s)
+	default:
+		return ErrUnsupportedKind
+	}
+	sub.subscriptions[id] = s
+	return nil
+}
+
+func (sub *Subscriber) Del(kind, key, group string) error {
+	sub.mu.Lock()
+	id := subscriptionID{kind, key, group}
+	s, ok := sub.subscriptions[id]
+	if !ok {
+		sub.mu.Unlock()
+		return ErrSubscriptionNotFound
+	}
+	delete(sub.subscriptions, id)
+	sub.mu.Unlock()
+
+	switch kind {
+	case KindSubscribe:
+		close(s.msgCh)
+		return sub.pubsub.Unsubscribe(sub.ctx, key)
+	case KindPSubscribe:
+		close(s.msgCh)
+		return sub.pubsub.PUnsubscribe(sub.ctx, key)
+	case KindStream:
+		// not waiting for the reader, the handler may be waiting for
+		// the caller. messages not handled yet are left pending.
+		s.cancel()
+	}
+	return nil
+}
+
+func (sub *Subscriber) Fini() {
+	sub.cancel()
+	sub.pubsub.Close()
+
+	sub.mu.Lock()
+	for id, s := range sub.subscriptions {
+		if s.msgCh != nil {
+			close(s.msgCh)
+		}
+		delete(sub.subscriptions, id)
+	}
+	sub.mu.Unlock()
+	sub.wg.Wait()
+}
+
+// receive returns after the pubsub is closed
+func (sub *Subscriber) receive() {
+	defer sub.wg.Done()
+
+	for msg := range sub.pubsub.Channel() {
+		kind, key := KindSubscribe, msg.Channel
+		if msg.Pattern != "" {
+			kind, key = KindPSubscribe, msg.Pattern
+		}
+		sub.mu.Lock()
+		s, ok := sub.subscriptions[subscriptionID{kind, key, ""}]
+		if ok {
+			select {
+			case s.msgCh <- &Message{
+				Kind:    kind,
+				Channel: msg.Channel,
+				Pattern: msg.Pattern,
+				Payload: msg.Payload,
+			}:
+			default:
+				// pub/sub is at most once, drop it rather than block others
+			}
+		}
+		sub.mu.Unlock()
+	}
+}
+
+// dispatch keeps the order of messages in one subscription
+func (sub *Subscriber) dispatch(s *subscription) {
+	defer sub.wg.Done()
+
+	for msg := range s.msgCh {
+		s.handler(msg)
+	}
+}
+
+// read consumes pending messages of this consumer first, which were
+// delivered but not acked, then new messages. messages pending too long
+// are claimed meanwhile.
+func (sub *Subscriber) read(ctx context.Context, s *subscription) {
+	defer sub.wg.Done()
+
+	// pending messages are read after the last one handled
+	id := "0"
+	claimed := time.Now()
+	for ctx.Err() == nil {
+		if time.Since(claimed) >= sub.claimIdle {
+			sub.claim(ctx, s)
+			claimed = time.Now()
+		}
+		streams, err := sub.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    s.group,
+			Consumer: sub.consumer,
+			Streams:  []string{s.key, id},
+			Count:    defaultStreamCount,
+			Block:    defaultStreamBlock,
+		}).Result()
+		if err != nil && err != redis.Nil {
+			if ctx.Err() == nil {
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+		count := 0
+		for _, stream := range streams {
+			for _, xmsg := range stream.Messages {
+				if ctx.Err() != nil {
+					return
+				}
+				count++
+				if id != ">" {
+					id = xmsg.ID
+				}
+				sub.handle(s, stream.Stream, xmsg)
+			}
+		}
+		if count == 0 {
+			// no more pending
+			id = ">"
+		}
+	}
+}
+
+// claim takes messages pending longer than claimIdle and handles them again
+func (sub *Subscriber) claim(ctx context.Context, s *subscription) {
+	pendings, err := sub.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: s.key,
+		Group:  s.group,
+		Start:  "-",
+		End:    "+",
+		Count:  defaultStreamCount,
+	}).Result()
+	if err != nil {
+		return
+	}
+	ids := []string{}
+	for _, pending := range pendings {
+		if pending.Idle >= sub.claimIdle {
+			ids = append(ids, pending.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	// claimed by others meanwhile are not returned
+	xmsgs, err := sub.client.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   s.key,
+		Group:    s.group,
+		Consumer: sub.consumer,
+		MinIdle:  sub.claimIdle,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		return
+	}
+	for _, xmsg := range xmsgs {
+		if ctx.Err() != nil {
+			return
+		}
+		sub.handle(s, s.key, xmsg)
+	}
+}
+
+// handle acks the message if it's handled without error, otherwise it's
+// left pending and claimed later
+func (sub *Subscriber) handle(s *subscription, stream string, xmsg redis.XMessage) {
+	msg := &Message{
+		Kind:    KindStream,
+		Channel: stream,
+		Group:   s.group,
+		ID:      xmsg.ID,
+		Values:  xmsg.Values,
+	}
+	s.handler(msg)
+	if msg.Error != nil {
+		return
+	}
+	sub.client.XAck(context.Background(), s.key, s.group, xmsg.ID)
+}
@@ -0,0 +1,23 @@
+package tbredis
+
+type TbMessage struct {
+	Kind    string
+	Channel string
+	Pattern string
+	Group   string
+	ID      string
+	Payload string
+	Values  map[string]interface{}
+}
+
+func Message2TbMessage(msg *Message) *TbMessage {
+	return &TbMessage{
+		Kind:    msg.Kind,
+		Channel: msg.Channel,
+		Pattern: msg.Pattern,
+		Group:   msg.Group,
+		ID:      msg.ID,
+		Payload: msg.Payload,
+		Values:  msg.Values,
+	}
+}
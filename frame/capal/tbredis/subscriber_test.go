@@ -0,0 +1,157 @@
+package tbredis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestSubscriber(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer mr.Close()
+
+	r, err := NewRedis(mr.Addr())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer r.Fini()
+	sub := NewSubscriber(r)
+	defer sub.Fini()
+
+	msgCh := make(chan *Message, 16)
+	handler := func(msg *Message) {
+		if msg.Kind == KindStream && msg.Values["fail"] == "1" {
+			msg.Error = errors.New("fail")
+		}
+		msgCh <- msg
+	}
+	for _, s := range [][]string{
+		{KindSubscribe, "foo", ""},
+		{KindPSubscribe, "bar.*", ""},
+		{KindStream, "stream", "group"},
+	} {
+		err = sub.Add(s[0], s[1], s[2], handler)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	// subscriptions are confirmed asynchronously
+	time.Sleep(100 * time.Millisecond)
+
+	ctx := context.Background()
+	client := r.Client()
+	client.Publish(ctx, "foo", "hello")
+	client.Publish(ctx, "bar.baz", "world")
+	client.XAdd(ctx, &redis.XAddArgs{Stream: "stream", Values: []string{"fail", "0"}})
+	client.XAdd(ctx, &redis.XAddArgs{Stream: "stream", Values: []string{"fail", "1"}})
+
+	got := map[string]string{}
+	for i := 0; i < 4; i++ {
+		select {
+		case msg := <-msgCh:
+			fail, _ := msg.Values["fail"].(string)
+			got[msg.Kind+msg.Channel+msg.Payload+fail] = msg.ID
+		case <-time.After(5 * time.Second):
+			t.Errorf("timeout, got: %v", got)
+			return
+		}
+	}
+	for _, key := range []string{"subscribefoohello", "psubscribebar.bazworld",
+		"streamstream0", "streamstream1"} {
+		if _, ok := got[key]; !ok {
+			t.Errorf("missing message: %s, got: %v", key, got)
+		}
+	}
+
+	// only the failed one is left pending
+	pending, err := client.XPending(ctx, "stream", "group").Result()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if pending.Count != 1 || pending.Lower != got["streamstream1"] {
+		t.Errorf("unexpected pending: %+v", pending)
+	}
+
+	err = sub.Del(KindSubscribe, "foo", "")
+	if err != nil {
+		t.Error(err)
+	}
+	err = sub.Del(KindStream, "stream", "group")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSubscriberClaim(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer mr.Close()
+
+	r, err := NewRedis(mr.Addr())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer r.Fini()
+	sub := NewSubscriber(r, OptionSubscriberClaimIdle(100*time.Millisecond))
+	defer sub.Fini()
+
+	// the first delivery fails, the claimed one succeeds
+	deliveries := make(chan string, 16)
+	failed := false
+	err = sub.Add(KindStream, "ab", "c", func(msg *Message) {
+		deliveries <- msg.ID
+		if !failed {
+			failed = true
+			msg.Error = errors.New("fail")
+		}
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// keys joined alike don't collide
+	err = sub.Add(KindStream, "a", "bc", func(msg *Message) {})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	ctx := context.Background()
+	client := r.Client()
+	id, _ := client.XAdd(ctx, &redis.XAddArgs{Stream: "ab", Values: []string{"foo", "bar"}}).Result()
+	for i := 0; i < 2; i++ {
+		select {
+		case got := <-deliveries:
+			if got != id {
+				t.Errorf("unexpected id: %s", got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("timeout waiting for claim")
+			return
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	pending, err := client.XPending(ctx, "ab", "c").Result()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if pending.Count != 0 {
+		t.Errorf("unexpected pending: %+v", pending)
+	}
+}
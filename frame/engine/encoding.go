@@ -0,0 +1,56 @@
+package engine
+
+import (
+	"encoding/base64"
+	"errors"
+)
+
+// encodings of binary data passing to and from javascript, data without an
+// encoding is taken as an utf-8 string, which is not binary safe
+const (
+	EncodingNone   = ""
+	EncodingBase64 = "base64"
+)
+
+var (
+	ErrUnsupportedEncoding = errors.New("unsupported encoding")
+)
+
+func Encode(data []byte, encoding string) (string, error) {
+	switch encoding {
+	case EncodingNone:
+		return string(data), nil
+	case EncodingBase64:
+		return base64.StdEncoding.EncodeToString(data), nil
+	}
+	return "", ErrUnsupportedEncoding
+}
+
+func Decode(data string, encoding string) ([]byte, error) {
+	switch encoding {
+	case EncodingNone:
+		return []byte(data), nil
+	case EncodingBase64:
+		return base64.StdEncoding.DecodeString(data)
+	}
+	return nil, ErrUnsupportedEncoding
+}
+
+// GetEncoding reads the encoding of an object, none if it's not set
+func GetEncoding(obj Value) (string, error) {
+	value, err := obj.Get("Encoding")
+	if err != nil {
+		return EncodingNone, err
+	}
+	if !value.IsDefined() || value.IsNull() {
+		return EncodingNone, nil
+	}
+	encoding, err := value.ToString()
+	if err != nil {
+		return EncodingNone, err
+	}
+	if encoding != EncodingNone && encoding != EncodingBase64 {
+		return EncodingNone, ErrUnsupportedEncoding
+	}
+	return encoding, nil
+}
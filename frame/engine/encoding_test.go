@@ -0,0 +1,46 @@
+package engine
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncoding(t *testing.T) {
+	data := []byte{0, 0xff, 0xfe, 0x80, 'a'}
+	for _, name := range []string{EngineOtto, EngineGoja} {
+		eng, err := New(name)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		vm := eng.NewVM()
+		encoded, err := Encode(data, EncodingBase64)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		// round trip through javascript
+		value, err := vm.ToValue(map[string]interface{}{
+			"Body":     encoded,
+			"Encoding": EncodingBase64,
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		encoding, err := GetEncoding(value)
+		if err != nil || encoding != EncodingBase64 {
+			t.Errorf("%s: unexpected encoding: %s, err: %v", name, encoding, err)
+		}
+		body, _ := value.Get("Body")
+		decoded, err := Decode(body.String(), encoding)
+		if err != nil || !bytes.Equal(decoded, data) {
+			t.Errorf("%s: unexpected decoded: %v, err: %v", name, decoded, err)
+		}
+
+		value, _ = vm.ToValue(map[string]interface{}{"Encoding": "gzip"})
+		if _, err = GetEncoding(value); err != ErrUnsupportedEncoding {
+			t.Errorf("%s: unexpected err: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"errors"
+)
+
+const (
+	EngineOtto = "otto" // ES5
+	EngineGoja = "goja" // ES2015+
+)
+
+var (
+	ErrInterrupted       = errors.New("interrupted")
+	ErrNotFunction       = errors.New("not function")
+	ErrUnsupportedEngine = errors.New("unsupported engine")
+)
+
+// Engine is a javascript interpreter backend, frame and capabilities
+// only talk to the interpreter through Engine, VM and Value.
+type Engine interface {
+	Name() string
+	// Compile parses src once, the program can be shared by VMs of
+	// the same engine.
+	Compile(file string, src []byte) (Program, error)
+	NewVM() VM
+}
+
+// Program is a compiled script, only the engine compiled it knows it.
+type Program interface{}
+
+// VM is not goroutine safe, use one VM in one goroutine at a time.
+type VM interface {
+	// value can be a go value, a Value, a Function or an Object
+	Set(name string, value interface{}) error
+	Get(name string) (Value, error)
+	Run(program Program) error
+	// Call calls fn with undefined as this, args can be go values or Values
+	Call(fn Value, args ...interface{}) (Value, error)
+	ToValue(value interface{}) (Value, error)
+	Undefined() Value
+	Null() Value
+	// Interrupt stops the running Run or Call, which returns ErrInterrupted.
+	// a VM interrupted must not be reused.
+	Interrupt()
+}
+
+type Value interface {
+	IsDefined() bool // not undefined
+	IsNull() bool
+	IsObject() bool
+	IsArray() bool
+	IsFunction() bool
+	IsString() bool
+	IsNumber() bool
+	IsBoolean() bool
+
+	String() string
+	ToString() (string, error)
+	ToInteger() (int64, error)
+	ToFloat() (float64, error)
+	ToBoolean() (bool, error)
+	Export() (interface{}, error)
+
+	// Get and Keys return undefined and nil for non objects
+	Get(key string) (Value, error)
+	Keys() []string
+}
+
+// Function is a go function callable from javascript
+type Function func(call FunctionCall) Value
+
+// Object is a javascript object made of go functions, like a capability
+type Object map[string]Function
+
+type FunctionCall struct {
+	VM           VM
+	ArgumentList []Value
+	// where the function is called from, for logging
+	Callee string
+	Line   int
+}
+
+func (call FunctionCall) Argument(index int) Value {
+	if index < 0 || index >= len(call.ArgumentList) {
+		return call.VM.Undefined()
+	}
+	return call.ArgumentList[index]
+}
+
+func New(name string) (Engine, error) {
+	switch name {
+	case "", EngineOtto:
+		return &ottoEngine{}, nil
+	case EngineGoja:
+		return &gojaEngine{}, nil
+	}
+	return nil, ErrUnsupportedEngine
+}
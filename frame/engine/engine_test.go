@@ -0,0 +1,69 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEngines(t *testing.T) {
+	for _, name := range []string{EngineOtto, EngineGoja} {
+		eng, err := New(name)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		program, err := eng.Compile(name+".js", []byte(`
+			function add(a, b) { return greet.Hello(a) + b }
+			function loop() { while (true) {} }`))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		vm := eng.NewVM()
+		err = vm.Set("greet", Object{
+			"Hello": func(call FunctionCall) Value {
+				value, _ := vm.ToValue("hello " + call.Argument(0).String())
+				return value
+			},
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		err = vm.Run(program)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		add, _ := vm.Get("add")
+		value, err := vm.Call(add, "foo", "!")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if value.String() != "hello foo!" {
+			t.Errorf("%s: unexpected value: %s", name, value.String())
+		}
+
+		loop, _ := vm.Get("loop")
+		time.AfterFunc(100*time.Millisecond, vm.Interrupt)
+		_, err = vm.Call(loop)
+		if err != ErrInterrupted {
+			t.Errorf("%s: unexpected err: %v", name, err)
+		}
+
+		// a program looping at top level is interrupted as well
+		program, err = eng.Compile(name+"_loop.js", []byte(`while (true) {}`))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		vm = eng.NewVM()
+		time.AfterFunc(100*time.Millisecond, vm.Interrupt)
+		err = vm.Run(program)
+		if err != ErrInterrupted {
+			t.Errorf("%s: unexpected run err: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package engine
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/dop251/goja"
+)
+
+type gojaEngine struct{}
+
+func (engine *gojaEngine) Name() string {
+	return EngineGoja
+}
+
+func (engine *gojaEngine) Compile(file string, src []byte) (Program, error) {
+	program, err := goja.Compile(file, string(src), false)
+	if err != nil {
+		return nil, err
+	}
+	return program, nil
+}
+
+func (engine *gojaEngine) NewVM() VM {
+	return &gojaVM{goja.New()}
+}
+
+type gojaVM struct {
+	vm *goja.Runtime
+}
+
+func (vm *gojaVM) Set(name string, value interface{}) error {
+	return vm.vm.Set(name, vm.toNative(value))
+}
+
+func (vm *gojaVM) Get(name string) (Value, error) {
+	return gojaValue{vm.vm.Get(name)}, nil
+}
+
+func (vm *gojaVM) Run(program Program) error {
+	native, ok := program.(*goja.Program)
+	if !ok {
+		return errors.New("not goja program")
+	}
+	_, err := vm.vm.RunProgram(native)
+	if _, ok := err.(*goja.InterruptedError); ok {
+		return ErrInterrupted
+	}
+	return err
+}
+
+func (vm *gojaVM) Call(fn Value, args ...interface{}) (Value, error) {
+	native, ok := fn.(gojaValue)
+	if !ok {
+		return nil, ErrNotFunction
+	}
+	callable, ok := goja.AssertFunction(native.value)
+	if !ok {
+		return nil, ErrNotFunction
+	}
+	natives := make([]goja.Value, len(args))
+	for index, arg := range args {
+		natives[index] = vm.vm.ToValue(vm.toNative(arg))
+	}
+	ret, err := callable(goja.Undefined(), natives...)
+	if err != nil {
+		if _, ok := err.(*goja.InterruptedError); ok {
+			return nil, ErrInterrupted
+		}
+		return nil, err
+	}
+	return gojaValue{ret}, nil
+}
+
+func (vm *gojaVM) ToValue(value interface{}) (Value, error) {
+	return gojaValue{vm.vm.ToValue(vm.toNative(value))}, nil
+}
+
+func (vm *gojaVM) Undefined() Value {
+	return gojaValue{goja.Undefined()}
+}
+
+func (vm *gojaVM) Null() Value {
+	return gojaValue{goja.Null()}
+}
+
+func (vm *gojaVM) Interrupt() {
+	vm.vm.Interrupt(ErrInterrupted)
+}
+
+func (vm *gojaVM) toNative(value interface{}) interface{} {
+	switch v := value.(type) {
+	case gojaValue:
+		return v.value
+	case Function:
+		return vm.function(v)
+	case Object:
+		object := vm.vm.NewObject()
+		for name, fn := range v {
+			object.Set(name, vm.function(fn))
+		}
+		return object
+	}
+	return value
+}
+
+func (vm *gojaVM) function(fn Function) func(goja.FunctionCall) goja.Value {
+	return func(call goja.FunctionCall) goja.Value {
+		args := make([]Value, len(call.Arguments))
+		for index, arg := range call.Arguments {
+			args[index] = gojaValue{arg}
+		}
+		fc := FunctionCall{
+			VM:           vm,
+			ArgumentList: args,
+		}
+		// the nearest javascript frame
+		for _, frame := range vm.vm.CaptureCallStack(0, nil) {
+			if position := frame.Position(); position.Line > 0 {
+				fc.Callee = frame.FuncName()
+				fc.Line = position.Line
+				break
+			}
+		}
+		ret := fn(fc)
+		if ret == nil {
+			return goja.Undefined()
+		}
+		if native, ok := ret.(gojaValue); ok && native.value != nil {
+			return native.value
+		}
+		return goja.Undefined()
+	}
+}
+
+// gojaTry turns panics of javascript exceptions into errors
+func gojaTry(fn func()) (err error) {
+	defer func() {
+		if caught := recover(); caught != nil {
+			if exception, ok := caught.(*goja.Exception); ok {
+				err = exception
+				return
+			}
+			panic(caught)
+		}
+	}()
+	fn()
+	return nil
+}
+
+// a nil value is taken as undefined
+type gojaValue struct {
+	value goja.Value
+}
+
+func (value gojaValue) kind() reflect.Kind {
+	if value.value == nil || value.IsObject() {
+		return reflect.Invalid
+	}
+	typ := value.value.ExportType()
+	if typ == nil {
+		return reflect.Invalid
+	}
+	return typ.Kind()
+}
+
+func (value gojaValue) IsDefined() bool {
+	return value.value != nil && !goja.IsUndefined(value.value)
+}
+
+func (value gojaValue) IsNull() bool {
+	return value.value != nil && goja.IsNull(value.value)
+}
+
+func (value gojaValue) IsObject() bool {
+	_, ok := value.value.(*goja.Object)
+	return ok
+}
+
+func (value gojaValue) IsArray() bool {
+	object, ok := value.value.(*goja.Object)
+	return ok && object.ClassName() == "Array"
+}
+
+func (value gojaValue) IsFunction() bool {
+	if value.value == nil {
+		return false
+	}
+	_, ok := goja.AssertFunction(value.value)
+	return ok
+}
+
+func (value gojaValue) IsString() bool {
+	return value.kind() == reflect.String
+}
+
+func (value gojaValue) IsNumber() bool {
+	switch value.kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func (value gojaValue) IsBoolean() bool {
+	return value.kind() == reflect.Bool
+}
+
+func (value gojaValue) String() string {
+	if value.value == nil {
+		return "undefined"
+	}
+	return value.value.String()
+}
+
+func (value gojaValue) ToString() (str string, err error) {
+	err = gojaTry(func() {
+		str = value.String()
+	})
+	return
+}
+
+func (value gojaValue) ToInteger() (integer int64, err error) {
+	if value.value == nil {
+		return 0, nil
+	}
+	err = gojaTry(func() {
+		integer = value.value.ToInteger()
+	})
+	return
+}
+
+func (value gojaValue) ToFloat() (float float64, err error) {
+	if value.value == nil {
+		return 0, nil
+	}
+	err = gojaTry(func() {
+		float = value.value.ToFloat()
+	})
+	return
+}
+
+func (value gojaValue) ToBoolean() (boolean bool, err error) {
+	if value.value == nil {
+		return false, nil
+	}
+	err = gojaTry(func() {
+		boolean = value.value.ToBoolean()
+	})
+	return
+}
+
+func (value gojaValue) Export() (exported interface{}, err error) {
+	if value.value == nil {
+		return nil, nil
+	}
+	err = gojaTry(func() {
+		exported = value.value.Export()
+	})
+	return
+}
+
+func (value gojaValue) Get(key string) (Value, error) {
+	object, ok := value.value.(*goja.Object)
+	if !ok {
+		return gojaValue{goja.Undefined()}, nil
+	}
+	var ret goja.Value
+	err := gojaTry(func() {
+		ret = object.Get(key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return gojaValue{ret}, nil
+}
+
+func (value gojaValue) Keys() []string {
+	object, ok := value.value.(*goja.Object)
+	if !ok {
+		return nil
+	}
+	return object.Keys()
+}
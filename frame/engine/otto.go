@@ -0,0 +1,240 @@
+package engine
+
+import (
+	"github.com/robertkrimen/otto"
+)
+
+type ottoEngine struct{}
+
+func (engine *ottoEngine) Name() string {
+	return EngineOtto
+}
+
+func (engine *ottoEngine) Compile(file string, src []byte) (Program, error) {
+	script, err := otto.New().Compile(file, src)
+	if err != nil {
+		return nil, err
+	}
+	return script, nil
+}
+
+func (engine *ottoEngine) NewVM() VM {
+	vm := otto.New()
+	// the buffer prevents blocking the interrupter
+	vm.Interrupt = make(chan func(), 1)
+	return &ottoVM{vm}
+}
+
+type ottoVM struct {
+	vm *otto.Otto
+}
+
+type ottoHalt struct{}
+
+func (vm *ottoVM) Set(name string, value interface{}) error {
+	native, err := vm.toNative(value)
+	if err != nil {
+		return err
+	}
+	return vm.vm.Set(name, native)
+}
+
+func (vm *ottoVM) Get(name string) (Value, error) {
+	value, err := vm.vm.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return ottoValue{value}, nil
+}
+
+func (vm *ottoVM) Run(program Program) (err error) {
+	defer func() {
+		if caught := recover(); caught != nil {
+			if _, ok := caught.(ottoHalt); ok {
+				err = ErrInterrupted
+				return
+			}
+			panic(caught)
+		}
+	}()
+	_, err = vm.vm.Run(program)
+	return err
+}
+
+func (vm *ottoVM) Call(fn Value, args ...interface{}) (value Value, err error) {
+	native, ok := fn.(ottoValue)
+	if !ok || !native.value.IsFunction() {
+		return nil, ErrNotFunction
+	}
+	natives := make([]interface{}, len(args))
+	for index, arg := range args {
+		natives[index], err = vm.toNative(arg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer func() {
+		if caught := recover(); caught != nil {
+			if _, ok := caught.(ottoHalt); ok {
+				value, err = nil, ErrInterrupted
+				return
+			}
+			panic(caught)
+		}
+	}()
+	ret, err := native.value.Call(otto.UndefinedValue(), natives...)
+	if err != nil {
+		return nil, err
+	}
+	return ottoValue{ret}, nil
+}
+
+func (vm *ottoVM) ToValue(value interface{}) (Value, error) {
+	native, err := vm.toNative(value)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := vm.vm.ToValue(native)
+	if err != nil {
+		return nil, err
+	}
+	return ottoValue{ret}, nil
+}
+
+func (vm *ottoVM) Undefined() Value {
+	return ottoValue{otto.UndefinedValue()}
+}
+
+func (vm *ottoVM) Null() Value {
+	return ottoValue{otto.NullValue()}
+}
+
+func (vm *ottoVM) Interrupt() {
+	select {
+	case vm.vm.Interrupt <- func() { panic(ottoHalt{}) }:
+	default:
+	}
+}
+
+func (vm *ottoVM) toNative(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case ottoValue:
+		return v.value, nil
+	case Function:
+		return vm.function(v), nil
+	case Object:
+		object, err := vm.vm.Object("({})")
+		if err != nil {
+			return nil, err
+		}
+		for name, fn := range v {
+			err = object.Set(name, vm.function(fn))
+			if err != nil {
+				return nil, err
+			}
+		}
+		return object, nil
+	}
+	return value, nil
+}
+
+func (vm *ottoVM) function(fn Function) func(otto.FunctionCall) otto.Value {
+	return func(call otto.FunctionCall) otto.Value {
+		args := make([]Value, len(call.ArgumentList))
+		for index, arg := range call.ArgumentList {
+			args[index] = ottoValue{arg}
+		}
+		ctx := call.Otto.Context()
+		ret := fn(FunctionCall{
+			VM:           vm,
+			ArgumentList: args,
+			Callee:       ctx.Callee,
+			Line:         ctx.Line,
+		})
+		if ret == nil {
+			return otto.UndefinedValue()
+		}
+		if native, ok := ret.(ottoValue); ok {
+			return native.value
+		}
+		return otto.UndefinedValue()
+	}
+}
+
+type ottoValue struct {
+	value otto.Value
+}
+
+func (value ottoValue) IsDefined() bool {
+	return value.value.IsDefined()
+}
+
+func (value ottoValue) IsNull() bool {
+	return value.value.IsNull()
+}
+
+func (value ottoValue) IsObject() bool {
+	return value.value.IsObject()
+}
+
+func (value ottoValue) IsArray() bool {
+	return value.value.IsObject() && value.value.Object().Class() == "Array"
+}
+
+func (value ottoValue) IsFunction() bool {
+	return value.value.IsFunction()
+}
+
+func (value ottoValue) IsString() bool {
+	return value.value.IsString()
+}
+
+func (value ottoValue) IsNumber() bool {
+	return value.value.IsNumber()
+}
+
+func (value ottoValue) IsBoolean() bool {
+	return value.value.IsBoolean()
+}
+
+func (value ottoValue) String() string {
+	return value.value.String()
+}
+
+func (value ottoValue) ToString() (string, error) {
+	return value.value.ToString()
+}
+
+func (value ottoValue) ToInteger() (int64, error) {
+	return value.value.ToInteger()
+}
+
+func (value ottoValue) ToFloat() (float64, error) {
+	return value.value.ToFloat()
+}
+
+func (value ottoValue) ToBoolean() (bool, error) {
+	return value.value.ToBoolean()
+}
+
+func (value ottoValue) Export() (interface{}, error) {
+	return value.value.Export()
+}
+
+func (value ottoValue) Get(key string) (Value, error) {
+	if !value.value.IsObject() {
+		return ottoValue{otto.UndefinedValue()}, nil
+	}
+	ret, err := value.value.Object().Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return ottoValue{ret}, nil
+}
+
+func (value ottoValue) Keys() []string {
+	if !value.value.IsObject() {
+		return nil
+	}
+	return value.value.Object().Keys()
+}
@@ -1,14 +1,18 @@
 package frame
 
 import (
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/Shopify/sarama"
 	"github.com/fsnotify/fsnotify"
 	"github.com/jumboframes/tigerbalm"
 	"github.com/jumboframes/tigerbalm/bus"
@@ -16,6 +20,7 @@ import (
 	"github.com/jumboframes/tigerbalm/frame/capal/tbhttp"
 	"github.com/jumboframes/tigerbalm/frame/capal/tbkafka"
 	"github.com/jumboframes/tigerbalm/frame/capal/tblog"
+	"github.com/jumboframes/tigerbalm/frame/capal/tbredis"
 )
 
 const (
@@ -23,28 +28,82 @@ const (
 	ExtLog = ".log"
 )
 
+// headers of dead letters
+const (
+	HeaderOriginTopic     = "x-origin-topic"
+	HeaderOriginPartition = "x-origin-partition"
+	HeaderOriginOffset    = "x-origin-offset"
+	HeaderError           = "x-error"
+)
+
+const (
+	ConflictHttp  = "http"
+	ConflictKafka = "kafka"
+	ConflictRedis = "redis"
+)
+
+// Conflict is a route or consume a plugin was rejected for
+type Conflict struct {
+	Kind   string // http, kafka or redis
+	Match  string // method and path, topic and group, or kind, key and group
+	Plugin string // the rejected plugin
+	Owner  string // the plugin owns it
+}
+
 type Frame struct {
-	namePlugins   map[string]*Plugin
-	httpPlugins   map[string]*Plugin
-	kafkaPlugins  map[string]*Plugin
-	pluginMux     sync.RWMutex
+	namePlugins  map[string]*Plugin
+	httpPlugins  map[string]*Plugin
+	kafkaPlugins map[string]*Plugin
+	redisPlugins map[RedisSubscribe]*Plugin
+	pluginMux    sync.RWMutex
+	// serializes loading, so checking conflicts and registering after are
+	// atomic among plugins
+	loadMux       sync.Mutex
 	pluginWatcher *fsnotify.Watcher
+	// conflicts of rejected plugins by plugin name
+	conflicts map[string][]Conflict
 
 	bus   bus.Bus
 	capal *capal.Capal
+	// shared by all plugins, nil if not enabled
+	redis *tbredis.Redis
+	// shared by plugins, released when plugins are unloaded
+	producers       *tbkafka.Producers
+	producerOptions []tbkafka.ProducerOption
 }
 
 func NewFrame(bus bus.Bus) (*Frame, error) {
 	frame := &Frame{
 		httpPlugins: make(map[string]*Plugin),
 		namePlugins: make(map[string]*Plugin),
+		conflicts:   make(map[string][]Conflict),
+		producers:   tbkafka.NewProducers(),
 		bus:         bus,
 	}
 	if tigerbalm.Conf.Kafka.Enable {
 		frame.kafkaPlugins = make(map[string]*Plugin)
 	}
-	frame.capal = capal.NewCapal(frame.httpFactory, frame.logFactory)
-	err := frame.loadPlugins()
+	if tigerbalm.Conf.Redis.Enable {
+		frame.redisPlugins = make(map[RedisSubscribe]*Plugin)
+	}
+	producerOptions, err := tbkafka.ProducerOptionsFromConf()
+	if err != nil {
+		tblog.Errorf("frame::newframe | kafka producer options err: %s", err)
+		return nil, err
+	}
+	frame.producerOptions = producerOptions
+	if tigerbalm.Conf.Redis.Enable {
+		redis, err := tbredis.NewRedisFromConf()
+		if err != nil {
+			tblog.Errorf("frame::newframe | new redis: %s err: %s",
+				tigerbalm.Conf.Redis.Addr, err)
+			return nil, err
+		}
+		frame.redis = redis
+	}
+	frame.capal = capal.NewCapal(frame.httpFactory, frame.logFactory,
+		frame.redisFactory, frame.producerFactory)
+	err = frame.loadPlugins()
 	if err != nil {
 		return nil, err
 	}
@@ -63,14 +122,9 @@ func NewFrame(bus bus.Bus) (*Frame, error) {
 }
 
 func (frame *Frame) Notify(os.Signal) {
-	err := frame.unloadPlugins()
+	err := frame.syncPlugins()
 	if err != nil {
-		tblog.Errorf("frame::notify | unload plugins err: %s", err)
-		return
-	}
-	err = frame.loadPlugins()
-	if err != nil {
-		tblog.Errorf("frame::notify | load plugins err: %s", err)
+		tblog.Errorf("frame::notify | sync plugins err: %s", err)
 		return
 	}
 }
@@ -123,20 +177,114 @@ func (frame *Frame) Fini() {
 	if tigerbalm.Conf.Plugin.WatchPath {
 		frame.pluginWatcher.Close()
 	}
+	frame.unloadPlugins()
+	// flush messages of producers left
+	frame.producers.Fini()
+	if frame.redis != nil {
+		frame.redis.Fini()
+	}
+}
+
+func (frame *Frame) PoolStats() map[string]PoolStats {
 	frame.pluginMux.RLock()
 	defer frame.pluginMux.RUnlock()
 
-	for _, plugin := range frame.namePlugins {
-		plugin.Fini()
-		frame.unregisterHttp(plugin)
-		frame.unregisterKafka(plugin)
+	stats := make(map[string]PoolStats, len(frame.namePlugins))
+	for name, plugin := range frame.namePlugins {
+		stats[name] = plugin.PoolStats()
+	}
+	return stats
+}
+
+// Conflicts returns conflicts of plugins rejected and not yet fixed
+func (frame *Frame) Conflicts() []Conflict {
+	frame.pluginMux.RLock()
+	defer frame.pluginMux.RUnlock()
+
+	conflicts := []Conflict{}
+	for _, cs := range frame.conflicts {
+		conflicts = append(conflicts, cs...)
 	}
+	return conflicts
+}
+
+// checkConflicts is called before a plugin version is swapped in, routes
+// and consumes owned by other plugins fail the loading.
+func (frame *Frame) checkConflicts(plugin *Plugin, routes []HttpRoute,
+	consumes []KafkaConsume, subscribes []RedisSubscribe) error {
+
+	frame.pluginMux.Lock()
+	defer frame.pluginMux.Unlock()
+
+	conflicts := []Conflict{}
+	for _, route := range routes {
+		owner, ok := frame.httpPlugins[routeKey(route)]
+		if ok && owner != plugin {
+			conflicts = append(conflicts, Conflict{
+				Kind:   ConflictHttp,
+				Match:  route.Method + " " + route.Path,
+				Plugin: plugin.Name(),
+				Owner:  owner.Name(),
+			})
+		}
+	}
+	if tigerbalm.Conf.Kafka.Enable {
+		for _, consume := range consumes {
+			owner, ok := frame.kafkaPlugins[consume.Topic+consume.Group]
+			if ok && owner != plugin {
+				conflicts = append(conflicts, Conflict{
+					Kind:   ConflictKafka,
+					Match:  consume.Topic + " " + consume.Group,
+					Plugin: plugin.Name(),
+					Owner:  owner.Name(),
+				})
+			}
+		}
+	}
+	if tigerbalm.Conf.Redis.Enable {
+		for _, subscribe := range subscribes {
+			owner, ok := frame.redisPlugins[subscribe]
+			if ok && owner != plugin {
+				conflicts = append(conflicts, Conflict{
+					Kind:   ConflictRedis,
+					Match:  strings.TrimSpace(subscribe.Kind + " " + subscribe.Key + " " + subscribe.Group),
+					Plugin: plugin.Name(),
+					Owner:  owner.Name(),
+				})
+			}
+		}
+	}
+	if len(conflicts) == 0 {
+		delete(frame.conflicts, plugin.Name())
+		return nil
+	}
+	frame.conflicts[plugin.Name()] = conflicts
+	for _, conflict := range conflicts {
+		tblog.Errorf("frame::checkconflicts | plugin: %s, %s: %s conflicts with plugin: %s",
+			conflict.Plugin, conflict.Kind, conflict.Match, conflict.Owner)
+	}
+	conflict := conflicts[0]
+	return fmt.Errorf("%w: %s: %s of plugin: %s, owned by plugin: %s",
+		tigerbalm.ErrConflict, conflict.Kind, conflict.Match,
+		conflict.Plugin, conflict.Owner)
 }
 
 func (frame *Frame) httpFactory(ctx *capal.PluginContext) *tbhttp.TbHttp {
 	return &tbhttp.TbHttp{}
 }
 
+func (frame *Frame) redisFactory(ctx *capal.PluginContext) *tbredis.TbRedis {
+	if frame.redis == nil {
+		return nil
+	}
+	return tbredis.NewTbRedis(frame.redis)
+}
+
+func (frame *Frame) producerFactory(ctx *capal.PluginContext) (*tbkafka.TbProducer, error) {
+	return frame.producers.Get(ctx.Name, tigerbalm.Conf.Kafka.Brokers,
+		frame.producerOptions...)
+}
+
 func (frame *Frame) logFactory(ctx *capal.PluginContext) *tblog.TbLog {
 	frame.pluginMux.RLock()
 	defer frame.pluginMux.RUnlock()
@@ -166,20 +314,93 @@ func (frame *Frame) loadPlugins() error {
 	return nil
 }
 
+// syncPlugins reloads existing plugins in place, loads new ones and
+// unloads the ones have gone, existing plugins keep serving all along.
+func (frame *Frame) syncPlugins() error {
+	files, err := ioutil.ReadDir(tigerbalm.Conf.Plugin.Path)
+	if err != nil {
+		return err
+	}
+
+	names := map[string]struct{}{}
+	for _, file := range files {
+		if file.IsDir() || path.Ext(file.Name()) != ExtJS {
+			continue
+		}
+		name := strings.TrimSuffix(file.Name(), ExtJS)
+		names[name] = struct{}{}
+
+		frame.pluginMux.RLock()
+		_, ok := frame.namePlugins[name]
+		frame.pluginMux.RUnlock()
+		if ok {
+			err = frame.reloadPlugin(file.Name())
+		} else {
+			err = frame.loadPlugin(file.Name())
+		}
+		if err != nil {
+			tblog.Errorf("frame::syncplugins | sync plugin: %s err: %s", name, err)
+		}
+	}
+
+	frame.pluginMux.RLock()
+	gone := []string{}
+	for name := range frame.namePlugins {
+		if _, ok := names[name]; !ok {
+			gone = append(gone, name)
+		}
+	}
+	frame.pluginMux.RUnlock()
+	for _, name := range gone {
+		frame.unloadPlugin(name + ExtJS)
+	}
+	return nil
+}
+
 func (frame *Frame) unloadPlugins() error {
-	frame.pluginMux.Lock()
-	defer frame.pluginMux.Unlock()
+	frame.loadMux.Lock()
+	defer frame.loadMux.Unlock()
 
+	frame.pluginMux.Lock()
+	plugins := make([]*Plugin, 0, len(frame.namePlugins))
 	for _, plugin := range frame.namePlugins {
-		plugin.Fini()
-		delete(frame.namePlugins, plugin.Name())
 		frame.unregisterHttp(plugin)
 		frame.unregisterKafka(plugin)
+		frame.unregisterTimer(plugin)
+		frame.unregisterRedis(plugin)
+		plugins = append(plugins, plugin)
+	}
+	frame.pluginMux.Unlock()
+
+	for _, plugin := range plugins {
+		frame.finiPlugin(plugin)
 	}
 	return nil
 }
 
+// finiPlugin must be called without holding pluginMux, the destroy hook
+// may require capabilities which look up the plugin by name.
+func (frame *Frame) finiPlugin(plugin *Plugin) {
+	plugin.Fini()
+	// after destroy hook, which may produce
+	frame.producers.Release(plugin.Name())
+
+	name := plugin.Name()
+	frame.pluginMux.Lock()
+	if frame.namePlugins[name] == plugin {
+		delete(frame.namePlugins, name)
+	}
+	frame.pluginMux.Unlock()
+}
+
 func (frame *Frame) loadPlugin(file string) error {
+	frame.loadMux.Lock()
+	defer frame.loadMux.Unlock()
+	return frame.loadPluginLocked(file)
+}
+
+// loadPluginLocked must be called with loadMux held
+func (frame *Frame) loadPluginLocked(file string) error {
 	name := strings.TrimSuffix(file, ExtJS)
 	pluginName, err := filepath.Abs(filepath.Join(tigerbalm.Conf.Plugin.Path, file))
 	if err != nil {
@@ -192,7 +413,8 @@ func (frame *Frame) loadPlugin(file string) error {
 		return err
 	}
 	// new plugin
-	plugin, err := NewPlugin(name, pluginCnt, frame.capal)
+	plugin, err := NewPlugin(name, pluginCnt, frame.capal,
+		OptionPluginCheck(frame.checkConflicts))
 	if err != nil {
 		tblog.Errorf("frame::loadplugin | new plugin: %s err: %s",
 			pluginName, err)
@@ -207,31 +429,47 @@ func (frame *Frame) loadPlugin(file string) error {
 	if err != nil {
 		tblog.Errorf("frame::loadplugin | plugin: %s load err: %s",
 			pluginName, err)
+		frame.finiPlugin(plugin)
 		return err
 	}
+	frame.pluginMux.Lock()
 	frame.registerHttp(plugin)
 	frame.registerKafka(plugin)
+	frame.registerTimer(plugin)
+	frame.registerRedis(plugin)
+	frame.pluginMux.Unlock()
 	return nil
 }
 
 func (frame *Frame) unloadPlugin(file string) error {
+	frame.loadMux.Lock()
+	defer frame.loadMux.Unlock()
+
 	name := strings.TrimSuffix(file, ExtJS)
 	frame.pluginMux.Lock()
-	defer frame.pluginMux.Unlock()
-
+	delete(frame.conflicts, name)
 	plugin, ok := frame.namePlugins[name]
-	if ok {
-		plugin.Fini()
-		delete(frame.namePlugins, name)
-		frame.unregisterHttp(plugin)
-		frame.unregisterKafka(plugin)
-	} else {
+	if !ok {
+		frame.pluginMux.Unlock()
 		tblog.Errorf("frame::unloadplugin | unload a non-exist plugin: %s", name)
+		return nil
 	}
+	frame.unregisterHttp(plugin)
+	frame.unregisterKafka(plugin)
+	frame.unregisterTimer(plugin)
+	frame.unregisterRedis(plugin)
+	frame.pluginMux.Unlock()
+
+	frame.finiPlugin(plugin)
 	return nil
 }
 
+// reloadPlugin keeps the old version serving until the new one is fully
+// loaded, only routes and consumes that differ are touched on the bus.
 func (frame *Frame) reloadPlugin(file string) error {
+	frame.loadMux.Lock()
+	defer frame.loadMux.Unlock()
+
 	name := strings.TrimSuffix(file, ExtJS)
 	pluginName := filepath.Join(tigerbalm.Conf.Plugin.Path, file)
 	pluginCnt, err := ioutil.ReadFile(pluginName)
@@ -240,83 +478,313 @@ func (frame *Frame) reloadPlugin(file string) error {
 			pluginName, err)
 		return err
 	}
+	frame.pluginMux.RLock()
+	plugin, ok := frame.namePlugins[name]
+	frame.pluginMux.RUnlock()
+	if !ok {
+		// a plugin rejected or failed before, give it another chance
+		return frame.loadPluginLocked(file)
+	}
+	oldRoutes := plugin.HttpRoutes()
+	oldConsumes := plugin.KafkaConsumes()
+	oldSchedules := plugin.TimerSchedules()
+	oldSubscribes := plugin.RedisSubscribes()
+
+	// reload runs destroy and init hooks, which may require capabilities
+	err = plugin.Reload(pluginCnt)
+	if err != nil {
+		tblog.Errorf("frame::reloadplugin | plugin: %s reload err: %s, keep old version serving",
+			name, err)
+		return err
+	}
+	newRoutes := plugin.HttpRoutes()
+	newConsumes := plugin.KafkaConsumes()
+	newSchedules := plugin.TimerSchedules()
+	newSubscribes := plugin.RedisSubscribes()
+
 	frame.pluginMux.Lock()
 	defer frame.pluginMux.Unlock()
+	frame.unregisterRoutes(plugin, diffRoutes(oldRoutes, newRoutes))
+	frame.registerRoutes(plugin, diffRoutes(newRoutes, oldRoutes))
+	// consumes of the same topic and group are swapped in place to keep
+	// the group membership
+	frame.unregisterConsumes(plugin, goneConsumes(oldConsumes, newConsumes))
+	frame.registerConsumes(plugin, diffConsumes(newConsumes, oldConsumes))
+	frame.unregisterSchedules(plugin, diffSchedules(oldSchedules, newSchedules))
+	frame.registerSchedules(plugin, diffSchedules(newSchedules, oldSchedules))
+	frame.unregisterSubscribes(plugin, diffSubscribes(oldSubscribes, newSubscribes))
+	frame.registerSubscribes(plugin, diffSubscribes(newSubscribes, oldSubscribes))
+	return nil
+}
 
-	plugin, ok := frame.namePlugins[name]
-	if ok {
-		// del slot before reload
-		frame.unregisterHttp(plugin)
-		frame.unregisterKafka(plugin)
-		err = plugin.Reload(pluginCnt)
-		if err != nil {
-			return err
+func (frame *Frame) registerHttp(plugin *Plugin) {
+	frame.registerRoutes(plugin, plugin.HttpRoutes())
+}
+
+func (frame *Frame) unregisterHttp(plugin *Plugin) {
+	frame.unregisterRoutes(plugin, plugin.HttpRoutes())
+}
+
+func (frame *Frame) registerRoutes(plugin *Plugin, routes []HttpRoute) {
+	for _, route := range routes {
+		owner, ok := frame.httpPlugins[routeKey(route)]
+		if ok && owner != plugin {
+			tblog.Errorf("frame::registerhttp | plugin: %s, method: %s, path: %s owned by plugin: %s",
+				plugin.Name(), route.Method, route.Path, owner.Name())
+			continue
+		}
+		frame.httpPlugins[routeKey(route)] = plugin
+		if frame.bus != nil {
+			frame.bus.AddSlotHandler(bus.SlotHttp,
+				httpHandlerFactory(plugin, route), route.Method, route.Path)
+			tblog.Debugf("frame::registerhttp | plugin: %s, method: %s, path: %s",
+				plugin.Name(), route.Method, route.Path)
 		}
-		frame.registerHttp(plugin)
-		frame.registerKafka(plugin)
-	} else {
-		tblog.Errorf("frame::unloadplugin | unload a non-exist plugin: %s", name)
 	}
-	return nil
 }
 
-func (frame *Frame) registerHttp(plugin *Plugin) {
-	if !plugin.Http() {
+func (frame *Frame) unregisterRoutes(plugin *Plugin, routes []HttpRoute) {
+	for _, route := range routes {
+		if frame.httpPlugins[routeKey(route)] != plugin {
+			continue
+		}
+		delete(frame.httpPlugins, routeKey(route))
+		if frame.bus != nil {
+			frame.bus.DelSlotHandler(bus.SlotHttp, route.Method, route.Path)
+			tblog.Debugf("frame::unregisterhttp | plugin: %s, method: %s, path: %s",
+				plugin.Name(), route.Method, route.Path)
+		}
+	}
+}
+
+func (frame *Frame) registerKafka(plugin *Plugin) {
+	frame.registerConsumes(plugin, plugin.KafkaConsumes())
+}
+
+func (frame *Frame) unregisterKafka(plugin *Plugin) {
+	frame.unregisterConsumes(plugin, plugin.KafkaConsumes())
+}
+
+func (frame *Frame) registerConsumes(plugin *Plugin, consumes []KafkaConsume) {
+	if !tigerbalm.Conf.Kafka.Enable {
 		return
 	}
-	route := plugin.HttpPath() + plugin.HttpMethod()
-	frame.httpPlugins[route] = plugin
-	if frame.bus != nil {
-		frame.bus.AddSlotHandler(bus.SlotHttp, httpHandlerFactory(plugin),
-			plugin.HttpMethod(), plugin.HttpPath())
-		tblog.Debugf("frame::registerhttp | plugin: %s, method: %s, path: %s",
-			plugin.Name(), plugin.HttpMethod(), plugin.HttpPath())
+	for _, consume := range consumes {
+		owner, ok := frame.kafkaPlugins[consume.Topic+consume.Group]
+		if ok && owner != plugin {
+			tblog.Errorf("frame::registerkafka | plugin: %s, topic: %s, group: %s owned by plugin: %s",
+				plugin.Name(), consume.Topic, consume.Group, owner.Name())
+			continue
+		}
+		frame.kafkaPlugins[consume.Topic+consume.Group] = plugin
+		if frame.bus != nil {
+			matches := []interface{}{consume.Topic, consume.Group}
+			if consume.Batch > 0 || consume.Concurrency > 1 {
+				matches = append(matches, consume.Batch, consume.Window, consume.Concurrency)
+			}
+			frame.bus.AddSlotHandler(bus.SlotKafka,
+				frame.kafkaHandlerFactory(plugin, consume), matches...)
+			tblog.Debugf("frame::registerkafka | plugin: %s, topic: %s, group: %s",
+				plugin.Name(), consume.Topic, consume.Group)
+		}
 	}
 }
 
-func (frame *Frame) unregisterHttp(plugin *Plugin) {
-	if !plugin.Http() {
+func (frame *Frame) unregisterConsumes(plugin *Plugin, consumes []KafkaConsume) {
+	if !tigerbalm.Conf.Kafka.Enable {
+		return
+	}
+	for _, consume := range consumes {
+		if frame.kafkaPlugins[consume.Topic+consume.Group] != plugin {
+			continue
+		}
+		delete(frame.kafkaPlugins, consume.Topic+consume.Group)
+		if frame.bus != nil {
+			frame.bus.DelSlotHandler(bus.SlotKafka, consume.Topic, consume.Group)
+			tblog.Debugf("frame::unregisterkafka | plugin: %s, topic: %s, group: %s",
+				plugin.Name(), consume.Topic, consume.Group)
+		}
+	}
+}
+
+func (frame *Frame) registerTimer(plugin *Plugin) {
+	frame.registerSchedules(plugin, plugin.TimerSchedules())
+}
+
+func (frame *Frame) unregisterTimer(plugin *Plugin) {
+	frame.unregisterSchedules(plugin, plugin.TimerSchedules())
+}
+
+// schedules are scoped by plugin, they never conflict
+func (frame *Frame) registerSchedules(plugin *Plugin, schedules []TimerSchedule) {
+	if frame.bus == nil {
 		return
 	}
-	route := plugin.HttpPath() + plugin.HttpMethod()
-	delete(frame.httpPlugins, route)
-	if frame.bus != nil {
-		frame.bus.DelSlotHandler(bus.SlotHttp,
-			plugin.HttpMethod(), plugin.HttpPath())
-		tblog.Debugf("frame::unregisterhttp | plugin: %s, method: %s, path: %s",
-			plugin.Name(), plugin.HttpMethod(), plugin.HttpPath())
+	for _, schedule := range schedules {
+		err := frame.bus.AddSlotHandler(bus.SlotTimer,
+			timerHandlerFactory(plugin, schedule), plugin.Name(),
+			schedule.Cron, schedule.Interval, schedule.Overlap)
+		if err != nil {
+			tblog.Errorf("frame::registertimer | plugin: %s, cron: %s, interval: %s, err: %s",
+				plugin.Name(), schedule.Cron, schedule.Interval, err)
+			continue
+		}
+		tblog.Debugf("frame::registertimer | plugin: %s, cron: %s, interval: %s",
+			plugin.Name(), schedule.Cron, schedule.Interval)
 	}
 }
 
-func (frame *Frame) registerKafka(plugin *Plugin) {
-	if !plugin.Kafka() || !tigerbalm.Conf.Kafka.Enable {
+func (frame *Frame) unregisterSchedules(plugin *Plugin, schedules []TimerSchedule) {
+	if frame.bus == nil {
 		return
 	}
-	tp := plugin.KafkaTopic() + plugin.KafkaGroup()
-	frame.kafkaPlugins[tp] = plugin
-	if frame.bus != nil {
-		frame.bus.AddSlotHandler(bus.SlotKafka, kafkaHandlerFactory(plugin),
-			plugin.KafkaTopic(), plugin.KafkaGroup())
-		tblog.Debugf("frame::registerkafka | plugin: %s, topic: %s, group: %s",
-			plugin.Name(), plugin.KafkaTopic(), plugin.KafkaGroup())
+	for _, schedule := range schedules {
+		frame.bus.DelSlotHandler(bus.SlotTimer, plugin.Name(),
+			schedule.Cron, schedule.Interval, schedule.Overlap)
+		tblog.Debugf("frame::unregistertimer | plugin: %s, cron: %s, interval: %s",
+			plugin.Name(), schedule.Cron, schedule.Interval)
 	}
 }
 
-func (frame *Frame) unregisterKafka(plugin *Plugin) {
-	if !plugin.Kafka() || !tigerbalm.Conf.Kafka.Enable {
+func (frame *Frame) registerRedis(plugin *Plugin) {
+	frame.registerSubscribes(plugin, plugin.RedisSubscribes())
+}
+
+func (frame *Frame) unregisterRedis(plugin *Plugin) {
+	frame.unregisterSubscribes(plugin, plugin.RedisSubscribes())
+}
+
+func (frame *Frame) registerSubscribes(plugin *Plugin, subscribes []RedisSubscribe) {
+	if !tigerbalm.Conf.Redis.Enable {
+		return
+	}
+	for _, subscribe := range subscribes {
+		owner, ok := frame.redisPlugins[subscribe]
+		if ok && owner != plugin {
+			tblog.Errorf("frame::registerredis | plugin: %s, kind: %s, key: %s, group: %s owned by plugin: %s",
+				plugin.Name(), subscribe.Kind, subscribe.Key, subscribe.Group, owner.Name())
+			continue
+		}
+		frame.redisPlugins[subscribe] = plugin
+		if frame.bus != nil {
+			frame.bus.AddSlotHandler(bus.SlotRedis,
+				redisHandlerFactory(plugin, subscribe),
+				subscribe.Kind, subscribe.Key, subscribe.Group)
+			tblog.Debugf("frame::registerredis | plugin: %s, kind: %s, key: %s, group: %s",
+				plugin.Name(), subscribe.Kind, subscribe.Key, subscribe.Group)
+		}
+	}
+}
+
+func (frame *Frame) unregisterSubscribes(plugin *Plugin, subscribes []RedisSubscribe) {
+	if !tigerbalm.Conf.Redis.Enable {
 		return
 	}
-	tp := plugin.KafkaTopic() + plugin.KafkaGroup()
-	delete(frame.kafkaPlugins, tp)
-	if frame.bus != nil {
-		frame.bus.DelSlotHandler(bus.SlotKafka,
-			plugin.KafkaTopic(), plugin.KafkaGroup())
-		tblog.Debugf("frame::unregisterkafka | plugin: %s, topic: %s, group: %s",
-			plugin.Name(), plugin.KafkaTopic(), plugin.KafkaGroup())
+	for _, subscribe := range subscribes {
+		if frame.redisPlugins[subscribe] != plugin {
+			continue
+		}
+		delete(frame.redisPlugins, subscribe)
+		if frame.bus != nil {
+			frame.bus.DelSlotHandler(bus.SlotRedis,
+				subscribe.Kind, subscribe.Key, subscribe.Group)
+			tblog.Debugf("frame::unregisterredis | plugin: %s, kind: %s, key: %s, group: %s",
+				plugin.Name(), subscribe.Kind, subscribe.Key, subscribe.Group)
+		}
+	}
+}
+
+// diffRoutes returns routes in a but not in b
+func diffRoutes(a, b []HttpRoute) []HttpRoute {
+	diff := []HttpRoute{}
+	for _, ra := range a {
+		found := false
+		for _, rb := range b {
+			if ra == rb {
+				found = true
+				break
+			}
+		}
+		if !found {
+			diff = append(diff, ra)
+		}
+	}
+	return diff
+}
+
+// goneConsumes returns consumes in a whose topic and group are not in b
+func goneConsumes(a, b []KafkaConsume) []KafkaConsume {
+	gone := []KafkaConsume{}
+	for _, ca := range a {
+		found := false
+		for _, cb := range b {
+			if ca.Topic == cb.Topic && ca.Group == cb.Group {
+				found = true
+				break
+			}
+		}
+		if !found {
+			gone = append(gone, ca)
+		}
+	}
+	return gone
+}
+
+// diffConsumes returns consumes in a but not in b
+func diffConsumes(a, b []KafkaConsume) []KafkaConsume {
+	diff := []KafkaConsume{}
+	for _, ca := range a {
+		found := false
+		for _, cb := range b {
+			if ca == cb {
+				found = true
+				break
+			}
+		}
+		if !found {
+			diff = append(diff, ca)
+		}
+	}
+	return diff
+}
+
+// diffSchedules returns schedules in a but not in b
+func diffSchedules(a, b []TimerSchedule) []TimerSchedule {
+	diff := []TimerSchedule{}
+	for _, sa := range a {
+		found := false
+		for _, sb := range b {
+			if sa == sb {
+				found = true
+				break
+			}
+		}
+		if !found {
+			diff = append(diff, sa)
+		}
+	}
+	return diff
+}
+
+// diffSubscribes returns subscribes in a but not in b
+func diffSubscribes(a, b []RedisSubscribe) []RedisSubscribe {
+	diff := []RedisSubscribe{}
+	for _, sa := range a {
+		found := false
+		for _, sb := range b {
+			if sa == sb {
+				found = true
+				break
+			}
+		}
+		if !found {
+			diff = append(diff, sa)
+		}
 	}
+	return diff
 }
 
-func httpHandlerFactory(plugin *Plugin) func(data interface{}) {
+func httpHandlerFactory(plugin *Plugin, route HttpRoute) func(data interface{}) {
 	return func(data interface{}) {
 		ctx, ok := data.(*bus.ContextHttp)
 		if !ok {
@@ -328,16 +796,43 @@ func httpHandlerFactory(plugin *Plugin) func(data interface{}) {
 			ctx.ResponseWriter().WriteHeader(http.StatusBadRequest)
 			return
 		}
-		rsp, err := plugin.HttpHandle(reqJS)
+		ctx.Params().Visit(func(key, value string) {
+			reqJS.Params[key] = value
+		})
+		w := tbhttp.NewResponseWriter(ctx.ResponseWriter(), ctx.Request().Context().Done())
+		rsp, err := plugin.HttpHandle(route, reqJS, w)
+		if w.Written() {
+			// the response is streamed, it ends as the handler returns
+			if err != nil {
+				tblog.Errorf("frame::handlehttp | plugin stream err: %s", err)
+			}
+			return
+		}
+		if err == tigerbalm.ErrNoSuchRoute {
+			// the route was removed by a reloading
+			ctx.ResponseWriter().WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err == tigerbalm.ErrTimeout {
+			ctx.ResponseWriter().WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
+		if err == tigerbalm.ErrPoolExhausted {
+			ctx.ResponseWriter().WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
 		if err != nil {
 			tblog.Errorf("frame::handlehttp | plugin handle err: %s", err)
 			ctx.ResponseWriter().WriteHeader(http.StatusInternalServerError)
 			return
 		}
 		header := ctx.ResponseWriter().Header()
-		for k, v := range rsp.Header {
+		for k, v := range rsp.HeaderValues {
 			if strings.ToLower(k) != strings.ToLower("Content-Length") {
-				header.Set(k, v)
+				header.Del(k)
+				for _, elem := range v {
+					header.Add(k, elem)
+				}
 			}
 		}
 		ctx.ResponseWriter().WriteHeader(rsp.Status)
@@ -345,13 +840,131 @@ func httpHandlerFactory(plugin *Plugin) func(data interface{}) {
 	}
 }
 
-func kafkaHandlerFactory(plugin *Plugin) func(data interface{}) {
+// the offset is marked only if the message is handled or dead-lettered
+func (frame *Frame) kafkaHandlerFactory(plugin *Plugin, consume KafkaConsume) func(data interface{}) {
+	return func(data interface{}) {
+		switch cg := data.(type) {
+		case *tbkafka.ConsumerGroupMessage:
+			tbmsg, _ := tbkafka.CGMessage2TbCGMessage(cg)
+			cg.Error = frame.kafkaRetry(cg.Context.Done(), plugin, consume, func() error {
+				return plugin.KafkaHandle(consume, tbmsg)
+			}, cg)
+
+		case *tbkafka.ConsumerGroupBatch:
+			tbmsgs := make([]*tbkafka.CGMessage, 0, len(cg.Messages))
+			for _, cgmsg := range cg.Messages {
+				tbmsg, _ := tbkafka.CGMessage2TbCGMessage(cgmsg)
+				tbmsgs = append(tbmsgs, tbmsg)
+			}
+			cg.Error = frame.kafkaRetry(cg.Context.Done(), plugin, consume, func() error {
+				return plugin.KafkaBatchHandle(consume, tbmsgs)
+			}, cg.Messages...)
+		}
+	}
+}
+
+// kafkaRetry retries handle by the policy, then dead-letters all messages.
+// A timeout fails like a throwing handler. A removed route or an exhausted
+// pool isn't the messages' fault, it's returned right away to be handled
+// again by the consumer, never dead-lettered. The backoff ends early if done
+// is closed, the messages are left to the consumer unmarked.
+func (frame *Frame) kafkaRetry(done <-chan struct{}, plugin *Plugin, consume KafkaConsume,
+	handle func() error, cgmsgs ...*tbkafka.ConsumerGroupMessage) error {
+
+	policy := plugin.KafkaPolicy(consume)
+	var err error
+	for attempt := 0; attempt <= policy.Retry; attempt++ {
+		if attempt > 0 && !backoff(done, policy.Backoff) {
+			return err
+		}
+		err = handle()
+		if err == nil || err == tigerbalm.ErrNoSuchRoute ||
+			err == tigerbalm.ErrPoolExhausted {
+			return err
+		}
+	}
+	if policy.DeadLetter == "" {
+		return err
+	}
+	for _, cgmsg := range cgmsgs {
+		if dlErr := frame.deadLetter(plugin, policy.DeadLetter, cgmsg, err); dlErr != nil {
+			return dlErr
+		}
+	}
+	return nil
+}
+
+// backoff waits d, false if done is closed first
+func backoff(done <-chan struct{}, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// deadLetter sends the message to the dead letter topic with the origin
+// and the error in headers
+func (frame *Frame) deadLetter(plugin *Plugin, topic string,
+	cgmsg *tbkafka.ConsumerGroupMessage, cause error) error {
+
+	producer, err := frame.producers.Get(plugin.Name(), tigerbalm.Conf.Kafka.Brokers,
+		frame.producerOptions...)
+	if err != nil {
+		tblog.Errorf("frame::deadletter | plugin: %s, get producer err: %s",
+			plugin.Name(), err)
+		return err
+	}
+	msg := &tbkafka.ProducerMessage{
+		Topic:     topic,
+		Payload:   cgmsg.Payload,
+		Partition: tbkafka.PartitionAuto,
+	}
+	if cgmsg.Key != nil {
+		msg.Key = sarama.ByteEncoder(cgmsg.Key)
+	}
+	for _, header := range cgmsg.Headers {
+		if header != nil {
+			msg.Headers = append(msg.Headers, *header)
+		}
+	}
+	msg.Headers = append(msg.Headers,
+		sarama.RecordHeader{Key: []byte(HeaderOriginTopic), Value: []byte(cgmsg.Topic)},
+		sarama.RecordHeader{Key: []byte(HeaderOriginPartition),
+			Value: []byte(strconv.Itoa(int(cgmsg.Partition)))},
+		sarama.RecordHeader{Key: []byte(HeaderOriginOffset),
+			Value: []byte(strconv.FormatInt(cgmsg.Offset, 10))},
+		sarama.RecordHeader{Key: []byte(HeaderError), Value: []byte(cause.Error())})
+	_, err = producer.Send(msg)
+	if err != nil {
+		tblog.Errorf("frame::deadletter | plugin: %s, topic: %s, partition: %d, offset: %d, dead letter: %s err: %s",
+			plugin.Name(), cgmsg.Topic, cgmsg.Partition, cgmsg.Offset, topic, err)
+		return err
+	}
+	tblog.Warnf("frame::deadletter | plugin: %s, topic: %s, partition: %d, offset: %d, dead lettered to: %s",
+		plugin.Name(), cgmsg.Topic, cgmsg.Partition, cgmsg.Offset, topic)
+	return nil
+}
+
+func timerHandlerFactory(plugin *Plugin, schedule TimerSchedule) func(data interface{}) {
+	return func(data interface{}) {
+		tick, ok := data.(*bus.ContextTimer)
+		if !ok {
+			return
+		}
+		plugin.TimerHandle(schedule, tick)
+	}
+}
+
+func redisHandlerFactory(plugin *Plugin, subscribe RedisSubscribe) func(data interface{}) {
 	return func(data interface{}) {
-		cgmsg, ok := data.(*tbkafka.ConsumerGroupMessage)
+		msg, ok := data.(*tbredis.Message)
 		if !ok {
 			return
 		}
-		tbmsg, _ := tbkafka.CGMessage2TbCGMessage(cgmsg)
-		plugin.KafkaHandle(tbmsg)
+		msg.Error = plugin.RedisHandle(subscribe, tbredis.Message2TbMessage(msg))
 	}
 }
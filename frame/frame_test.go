@@ -2,6 +2,7 @@ package frame
 
 import (
 	"net/http"
+	"os"
 	"testing"
 
 	"github.com/jumboframes/tigerbalm"
@@ -9,8 +10,10 @@ import (
 )
 
 func (frame *Frame) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	key := req.URL.Path + req.Method
-	plugin, ok := frame.pathPlugins[key]
+	route := HttpRoute{Path: req.URL.Path, Method: req.Method}
+	frame.pluginMux.RLock()
+	plugin, ok := frame.httpPlugins[routeKey(route)]
+	frame.pluginMux.RUnlock()
 	if !ok {
 		w.WriteHeader(http.StatusNotFound)
 		return
@@ -20,16 +23,25 @@ func (frame *Frame) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	rsp, err := plugin.Handle(reqJS)
+	rsp, err := plugin.HttpHandle(route, reqJS,
+		tbhttp.NewResponseWriter(w, req.Context().Done()))
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if rsp == nil {
+		// streamed
+		return
+	}
 	w.WriteHeader(rsp.Status)
 	w.Write([]byte(rsp.Body))
 }
 
+// TestFrame serves plugins of ../js until killed, set TIGERBALM_SERVE to run
 func TestFrame(t *testing.T) {
+	if os.Getenv("TIGERBALM_SERVE") == "" {
+		t.Skip("TIGERBALM_SERVE not set")
+	}
 	config := &tigerbalm.Config{}
 	config.Plugin.Path = "../js"
 	config.Plugin.Log.Path = "/tmp/tigerbalm/log/plugin"
@@ -41,8 +53,9 @@ func TestFrame(t *testing.T) {
 	config.Log.MaxSize = 10485760
 	config.Log.MaxRolls = 10
 	config.Log.File = "/tmp/tigerbalm/log/tigerbalm.log"
+	tigerbalm.Conf = config
 
-	frame, err := NewFrame(nil, config)
+	frame, err := NewFrame(nil)
 	if err != nil {
 		t.Error(err)
 		return
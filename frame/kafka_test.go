@@ -0,0 +1,54 @@
+package frame
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jumboframes/tigerbalm"
+)
+
+func TestKafkaRetry(t *testing.T) {
+	consume := KafkaConsume{Topic: "a", Group: "g"}
+	plugin := &Plugin{policies: map[KafkaConsume]ConsumePolicy{
+		consume: {Retry: 2, Backoff: 10 * time.Millisecond},
+	}}
+	tests := []struct {
+		name    string
+		err     error
+		closed  bool
+		handled int
+	}{{
+		name:    "timeout retried",
+		err:     tigerbalm.ErrTimeout,
+		handled: 3,
+	}, {
+		name:    "failure retried",
+		err:     tigerbalm.ErrHandleFailed,
+		handled: 3,
+	}, {
+		name:    "exhausted pool not retried",
+		err:     tigerbalm.ErrPoolExhausted,
+		handled: 1,
+	}, {
+		name:    "claim ended while backing off",
+		err:     tigerbalm.ErrHandleFailed,
+		closed:  true,
+		handled: 1,
+	}}
+	frame := &Frame{}
+	for _, test := range tests {
+		done := make(chan struct{})
+		if test.closed {
+			close(done)
+		}
+		handled := 0
+		err := frame.kafkaRetry(done, plugin, consume, func() error {
+			handled++
+			return test.err
+		})
+		// without a dead letter topic, the last error is left to the consumer
+		if err != test.err || handled != test.handled {
+			t.Errorf("%s: unexpected handled: %d, err: %v", test.name, handled, err)
+		}
+	}
+}
@@ -1,29 +1,87 @@
 package frame
 
 import (
+	"fmt"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/jumboframes/tigerbalm"
+	"github.com/jumboframes/tigerbalm/bus"
 	"github.com/jumboframes/tigerbalm/frame/capal"
 	"github.com/jumboframes/tigerbalm/frame/capal/tbhttp"
 	"github.com/jumboframes/tigerbalm/frame/capal/tbkafka"
 	"github.com/jumboframes/tigerbalm/frame/capal/tblog"
+	"github.com/jumboframes/tigerbalm/frame/capal/tbredis"
+	"github.com/jumboframes/tigerbalm/frame/engine"
 	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
+)
 
-	"github.com/robertkrimen/otto"
+const (
+	defaultStreamTimeout = 10 * time.Minute
 )
 
+type HttpRoute struct {
+	Path   string
+	Method string
+}
+
+// messages are handled in batches if Batch is set, or in Concurrency lanes
+// of keys
+type KafkaConsume struct {
+	Topic       string
+	Group       string
+	Batch       int
+	Window      time.Duration
+	Concurrency int
+}
+
+// a failed message is retried, then sent to the dead letter topic if set
+type ConsumePolicy struct {
+	Retry      int
+	Backoff    time.Duration
+	DeadLetter string
+}
+
+// one of Cron and Interval is set
+type TimerSchedule struct {
+	Cron     string
+	Interval time.Duration
+	Overlap  string
+}
+
+// Key is a channel, a pattern or a stream key, Group is for streams only
+type RedisSubscribe struct {
+	Kind  string
+	Key   string
+	Group string
+}
+
+type PluginOption func(*Plugin)
+
+func OptionPluginCheck(check func(*Plugin, []HttpRoute, []KafkaConsume,
+	[]RedisSubscribe) error) PluginOption {
+	return func(plugin *Plugin) {
+		plugin.check = check
+	}
+}
+
 type Plugin struct {
 	mu sync.RWMutex
 	// metas
-	http       bool
-	httpPath   string
-	httpMethod string
+	http   bool
+	routes []HttpRoute
 
-	kafka      bool
-	kafkaTopic string
-	kafkaGroup string
+	kafka    bool
+	consumes []KafkaConsume
+	// policies may change by reloading without re-registering
+	policies map[KafkaConsume]ConsumePolicy
+
+	timer     bool
+	schedules []TimerSchedule
+
+	redis      bool
+	subscribes []RedisSubscribe
 
 	name    string
 	content []byte
@@ -32,9 +90,13 @@ type Plugin struct {
 	capal *capal.Capal
 	ctx   *capal.PluginContext
 
+	engine engine.Engine
+	// check validates a new version before it's swapped in
+	check func(*Plugin, []HttpRoute, []KafkaConsume, []RedisSubscribe) error
+
 	// runtimes
-	httpPool  sync.Pool
-	kafkaPool sync.Pool
+	pool   *vmPool
+	loaded bool
 
 	// logs
 	log       *tblog.TbLog
@@ -44,21 +106,39 @@ type Plugin struct {
 // plugin uses name as runtime context indexing for looking-up resources,
 // like logging instance. the changing of name must then recreate ctx.
 func NewPlugin(name string, content []byte,
-	cpl *capal.Capal) (*Plugin, error) {
+	cpl *capal.Capal, options ...PluginOption) (*Plugin, error) {
 
+	eng, err := engine.New(engineName(name))
+	if err != nil {
+		tblog.Errorf("newplugin | plugin: %s, new engine: %s err: %s",
+			name, engineName(name), err)
+		return nil, err
+	}
 	plugin := &Plugin{
 		name:    name,
 		content: content,
 		capal:   cpl,
 		ctx:     &capal.PluginContext{name},
+		engine:  eng,
 	}
-	err := plugin.newLog()
+	for _, option := range options {
+		option(plugin)
+	}
+	err = plugin.newLog()
 	if err != nil {
 		return nil, err
 	}
 	return plugin, nil
 }
 
+// engine of the plugin in config overrides the global one
+func engineName(name string) string {
+	if eng, ok := tigerbalm.Conf.Plugin.Engines[name]; ok {
+		return eng
+	}
+	return tigerbalm.Conf.Plugin.Engine
+}
+
 func (plugin *Plugin) newLog() error {
 	if plugin.rotateLog != nil {
 		plugin.rotateLog.Close()
@@ -86,35 +166,104 @@ func (plugin *Plugin) newLog() error {
 }
 
 func (plugin *Plugin) Load() error {
+	plugin.mu.RLock()
+	content := plugin.content
+	plugin.mu.RUnlock()
+	return plugin.load(content)
+}
+
+// load builds and validates a new version from content, the new version
+// is swapped in only if it's fully ready, otherwise the old one keeps
+// serving. the old version is destroyed after the swapping.
+func (plugin *Plugin) load(content []byte) error {
+	// compile once, the program is shared by all runtimes of this version
+	program, err := plugin.compile(content)
+	if err != nil {
+		return err
+	}
 	// plugin runtime
-	runtime, err := plugin.vmFactory()
+	rt, err := plugin.vmFactory(program)
 	if err != nil {
 		tblog.Errorf("newplugin | plugin: %s, get vm err: %s",
 			plugin.name, err)
 		return err
 	}
-	plugin.mu.Lock()
-	if runtime.route != nil {
-		httpPool := sync.Pool{
-			New: plugin.httpHandlerFactory,
+	routes := []HttpRoute{}
+	for _, route := range rt.routes {
+		routes = append(routes, HttpRoute{
+			Path:   route.path,
+			Method: route.method,
+		})
+	}
+	consumes := []KafkaConsume{}
+	policies := map[KafkaConsume]ConsumePolicy{}
+	for _, consume := range rt.consumes {
+		kc := KafkaConsume{
+			Topic:       consume.topic,
+			Group:       consume.group,
+			Batch:       consume.batch,
+			Window:      consume.window,
+			Concurrency: consume.concurrency,
 		}
-		httpPool.Put(runtime.route.handler)
-		plugin.http = true
-		plugin.httpPath = runtime.route.path
-		plugin.httpMethod = runtime.route.method
-		plugin.httpPool = httpPool
-	}
-	if runtime.consume != nil {
-		kafkaPool := sync.Pool{
-			New: plugin.kafkaHandlerFactory,
+		consumes = append(consumes, kc)
+		policies[kc] = consume.policy
+	}
+	schedules := []TimerSchedule{}
+	for _, schedule := range rt.schedules {
+		schedules = append(schedules, schedule.TimerSchedule)
+	}
+	subscribes := []RedisSubscribe{}
+	for _, subscribe := range rt.subscribes {
+		subscribes = append(subscribes, subscribe.RedisSubscribe)
+	}
+	if plugin.check != nil {
+		err = plugin.check(plugin, routes, consumes, subscribes)
+		if err != nil {
+			plugin.log.Errorf("plugin: %s, check err: %s", plugin.name, err)
+			return err
 		}
-		kafkaPool.Put(runtime.consume.handler)
-		plugin.kafka = true
-		plugin.kafkaTopic = runtime.consume.topic
-		plugin.kafkaGroup = runtime.consume.group
-		plugin.kafkaPool = kafkaPool
 	}
+
+	// init is optional, a failed init fails the loading
+	err = plugin.initRuntime(rt)
+	if err != nil {
+		return err
+	}
+	pool := newVMPoolFromConf(func() (*runtime, error) {
+		rt, err := plugin.vmFactory(program)
+		if err != nil {
+			return nil, err
+		}
+		return rt, plugin.initRuntime(rt)
+	})
+	pool.add(rt)
+	err = pool.warm()
+	if err != nil {
+		plugin.log.Errorf("plugin: %s, warm pool err: %s", plugin.name, err)
+		// the runtimes initialized are destroyed, the version never serves
+		plugin.destroyPool(pool)
+		return err
+	}
+
+	plugin.mu.Lock()
+	oldPool, loaded := plugin.pool, plugin.loaded
+	plugin.content = content
+	plugin.pool = pool
+	plugin.loaded = true
+	plugin.http = len(routes) != 0
+	plugin.routes = routes
+	plugin.kafka = len(consumes) != 0
+	plugin.consumes = consumes
+	plugin.policies = policies
+	plugin.timer = len(schedules) != 0
+	plugin.schedules = schedules
+	plugin.redis = len(subscribes) != 0
+	plugin.subscribes = subscribes
 	plugin.mu.Unlock()
+
+	if loaded {
+		plugin.destroyPool(oldPool)
+	}
 	return nil
 }
 
@@ -134,15 +283,62 @@ func (plugin *Plugin) Rename(name string) error {
 }
 
 func (plugin *Plugin) Reload(content []byte) error {
+	return plugin.load(content)
+}
+
+// destroy calls the optional destroy hook once if the plugin was loaded
+func (plugin *Plugin) destroy() {
 	plugin.mu.Lock()
-	plugin.content = content
+	loaded, pool := plugin.loaded, plugin.pool
+	plugin.loaded = false
 	plugin.mu.Unlock()
+	if !loaded {
+		return
+	}
+	plugin.destroyPool(pool)
+}
+
+// destroyPool retires the pool, so runtimes of the version are no longer
+// made, which may require released capabilities. idle runtimes are
+// destroyed now, runtimes in use are destroyed as they are put.
+func (plugin *Plugin) destroyPool(pool *vmPool) {
+	for _, rt := range pool.retire() {
+		plugin.destroyRuntime(rt)
+	}
+}
+
+// init runs in every runtime as it's made, globals are per runtime
+func (plugin *Plugin) initRuntime(rt *runtime) error {
+	err := rt.callHook(handlerTimeout(rt.registration), FuncInit)
+	if err != nil {
+		plugin.log.Errorf("plugin: %s, init err: %s", plugin.name, err)
+	}
+	return err
+}
+
+func (plugin *Plugin) destroyRuntime(rt *runtime) {
+	err := rt.callHook(handlerTimeout(rt.registration), FuncDestroy)
+	if err != nil {
+		plugin.log.Errorf("plugin: %s, destroy err: %s", plugin.name, err)
+	}
+}
+
+// compile reports syntax errors with file and line
+func (plugin *Plugin) compile(content []byte) (engine.Program, error) {
+	plugin.mu.RLock()
+	file := plugin.name + ExtJS
+	plugin.mu.RUnlock()
 
-	return plugin.Load()
+	program, err := plugin.engine.Compile(file, content)
+	if err != nil {
+		plugin.log.Errorf("plugin: %s, compile err: %s", file, err)
+		return nil, err
+	}
+	return program, nil
 }
 
-func (plugin *Plugin) vmFactory() (*runtime, error) {
-	vm := otto.New()
+func (plugin *Plugin) vmFactory(program engine.Program) (*runtime, error) {
+	vm := plugin.engine.NewVM()
 	plugin.mu.RLock()
 	err := vm.Set(VarContext, plugin.ctx)
 	plugin.mu.RUnlock()
@@ -151,23 +347,29 @@ func (plugin *Plugin) vmFactory() (*runtime, error) {
 		return nil, err
 	}
 
-	err = vm.Set(FuncRequire, plugin.capal.Require)
+	err = vm.Set(FuncRequire, engine.Function(plugin.capal.Require))
 	if err != nil {
 		plugin.log.Errorf("vm factory set require err: %s", err)
 		return nil, err
 	}
 
-	plugin.mu.RLock()
-	content := plugin.content
-	plugin.mu.RUnlock()
+	// the program and register run with the default timeout, a hanging
+	// one would hold the loading of all plugins
+	rt := &runtime{vm: vm}
+	timeout := handlerTimeout(nil)
 	// watch out the concurrency condition in script
-	_, err = vm.Run(content)
+	err = rt.run(timeout, program)
 	if err != nil {
-		plugin.log.Errorf("vm factory run content err: %s", err)
+		plugin.log.Errorf("vm factory run program err: %s", err)
 		return nil, err
 	}
 
-	pr, err := vm.Call(FuncRegister, nil)
+	register, err := vm.Get(FuncRegister)
+	if err != nil {
+		plugin.log.Errorf("vm factory get register err: %s", err)
+		return nil, err
+	}
+	pr, err := rt.call(timeout, register)
 	if err != nil {
 		plugin.log.Errorf("vm factory register err: %s", err)
 		return nil, err
@@ -177,68 +379,67 @@ func (plugin *Plugin) vmFactory() (*runtime, error) {
 		return nil, tigerbalm.ErrRegisterNotObject
 	}
 
-	registration, err := getRegistration(pr.Object())
+	registration, err := getRegistration(pr)
 	if err != nil {
 		plugin.log.Errorf("vm factory get registration err: %s", err)
 		return nil, err
 	}
-	return &runtime{registration, vm}, nil
+	rt.registration = registration
+	return rt, nil
 }
 
-func (plugin *Plugin) httpHandlerFactory() interface{} {
-	runtime, err := plugin.vmFactory()
-	if err != nil {
-		plugin.log.Errorf("plugin: %s, http handler factory get vm err: %s",
-			plugin.name, err)
-		return nil
-	}
-	return runtime.route.handler
+func (plugin *Plugin) Http() bool {
+	plugin.mu.RLock()
+	defer plugin.mu.RUnlock()
+	return plugin.http
 }
 
-func (plugin *Plugin) kafkaHandlerFactory() interface{} {
-	runtime, err := plugin.vmFactory()
-	if err != nil {
-		plugin.log.Errorf("plugin: %s, kafka handler factory get vm err: %s",
-			plugin.name, err)
-		return nil
-	}
-	return runtime.consume.handler
+func (plugin *Plugin) HttpRoutes() []HttpRoute {
+	plugin.mu.RLock()
+	defer plugin.mu.RUnlock()
+	return plugin.routes
 }
 
-func (plugin *Plugin) Http() bool {
+func (plugin *Plugin) Kafka() bool {
 	plugin.mu.RLock()
 	defer plugin.mu.RUnlock()
-	return plugin.http
+	return plugin.kafka
 }
 
-func (plugin *Plugin) HttpMethod() string {
+func (plugin *Plugin) KafkaConsumes() []KafkaConsume {
 	plugin.mu.RLock()
 	defer plugin.mu.RUnlock()
-	return plugin.httpMethod
+	return plugin.consumes
 }
 
-func (plugin *Plugin) HttpPath() string {
+func (plugin *Plugin) Timer() bool {
 	plugin.mu.RLock()
 	defer plugin.mu.RUnlock()
-	return plugin.httpPath
+	return plugin.timer
 }
 
-func (plugin *Plugin) Kafka() bool {
+func (plugin *Plugin) TimerSchedules() []TimerSchedule {
 	plugin.mu.RLock()
 	defer plugin.mu.RUnlock()
-	return plugin.kafka
+	return plugin.schedules
 }
 
-func (plugin *Plugin) KafkaTopic() string {
+func (plugin *Plugin) Redis() bool {
 	plugin.mu.RLock()
 	defer plugin.mu.RUnlock()
-	return plugin.kafkaTopic
+	return plugin.redis
 }
 
-func (plugin *Plugin) KafkaGroup() string {
+func (plugin *Plugin) RedisSubscribes() []RedisSubscribe {
 	plugin.mu.RLock()
 	defer plugin.mu.RUnlock()
-	return plugin.kafkaGroup
+	return plugin.subscribes
+}
+
+func (plugin *Plugin) KafkaPolicy(consume KafkaConsume) ConsumePolicy {
+	plugin.mu.RLock()
+	defer plugin.mu.RUnlock()
+	return plugin.policies[consume]
 }
 
 func (plugin *Plugin) Log() *tblog.TbLog {
@@ -247,47 +448,246 @@ func (plugin *Plugin) Log() *tblog.TbLog {
 	return plugin.log
 }
 
-func (plugin *Plugin) HttpHandle(req *tbhttp.Request) (*tbhttp.Response, error) {
-	handler := plugin.httpPool.Get()
-	defer plugin.httpPool.Put(handler)
+func (plugin *Plugin) runtimePool() *vmPool {
+	plugin.mu.RLock()
+	defer plugin.mu.RUnlock()
+	return plugin.pool
+}
+
+// getRuntime gets a runtime of the current version, a version replaced
+// meanwhile is skipped
+func (plugin *Plugin) getRuntime() (*vmPool, *runtime, error) {
+	pool := plugin.runtimePool()
+	for {
+		if pool == nil {
+			plugin.log.Error("plugin not loaded")
+			return nil, nil, tigerbalm.ErrNewInterpreter
+		}
+		rt, err := pool.get()
+		if err == errPoolRetired {
+			if current := plugin.runtimePool(); current != pool {
+				pool = current
+				continue
+			}
+		}
+		if err != nil {
+			plugin.log.Errorf("plugin get runtime from pool err: %s", err)
+			return nil, nil, err
+		}
+		return pool, rt, nil
+	}
+}
+
+// broken runtimes are thrown away, the pool will make new ones, runtimes
+// of a retired pool are destroyed
+func (plugin *Plugin) putRuntime(pool *vmPool, rt *runtime) {
+	if rt.broken {
+		plugin.log.Warn("plugin drop a broken runtime")
+		pool.drop(rt)
+		return
+	}
+	if !pool.put(rt) {
+		plugin.destroyRuntime(rt)
+	}
+}
+
+func (plugin *Plugin) PoolStats() PoolStats {
+	pool := plugin.runtimePool()
+	if pool == nil {
+		return PoolStats{}
+	}
+	return pool.stats()
+}
+
+func handlerTimeout(registration *registration) time.Duration {
+	if registration != nil && registration.timeout > 0 {
+		return registration.timeout
+	}
+	return tigerbalm.Conf.Plugin.Timeout * time.Second
+}
 
-	if handler == nil {
-		plugin.log.Error("plugin get nil handler from pool")
-		return nil, tigerbalm.ErrNewInterpreter
+// a streaming handler is interrupted after it however it keeps writing
+func streamTimeout() time.Duration {
+	if tigerbalm.Conf.Plugin.StreamTimeout > 0 {
+		return tigerbalm.Conf.Plugin.StreamTimeout * time.Second
 	}
-	this, err := otto.ToValue(nil)
+	return defaultStreamTimeout
+}
+
+// the handler is looked up by route in current version, so a reloading
+// doesn't need to re-register unchanged routes. A handler writing through w
+// has its return value ignored and its deadline extended by each write, up
+// to the stream timeout. It's interrupted once the client disconnects.
+func (plugin *Plugin) HttpHandle(route HttpRoute, req *tbhttp.Request,
+	w *tbhttp.ResponseWriter) (*tbhttp.Response, error) {
+	pool, rt, err := plugin.getRuntime()
 	if err != nil {
-		plugin.log.Errorf("plugin to value err: %s", err)
 		return nil, err
 	}
-	ottoRsp, err := handler.(otto.Value).Call(this, req)
+	defer plugin.putRuntime(pool, rt)
+
+	r, ok := rt.routeHandler(route)
+	if !ok {
+		plugin.log.Errorf("plugin route: %s %s not found", route.Method, route.Path)
+		return nil, tigerbalm.ErrNoSuchRoute
+	}
+	if r.encoding != engine.EncodingNone {
+		encoded := *req
+		if err = encoded.EncodeBody(r.encoding); err != nil {
+			return nil, err
+		}
+		req = &encoded
+	}
+	w.OnWrite(rt.extend)
+	defer w.Close()
+	value, err := rt.callStream(handlerTimeout(rt.registration), streamTimeout(), w.Done(),
+		r.handler, req, w.Object())
 	if err != nil {
 		plugin.log.Errorf("plugin call err: %s", err)
 		return nil, err
 	}
+	if w.Written() {
+		return nil, nil
+	}
 
-	return tbhttp.OttoValue2TbRsp(ottoRsp)
+	return tbhttp.Value2TbRsp(value)
 }
 
-func (plugin *Plugin) KafkaHandle(msg *tbkafka.CGMessage) {
-	handler := plugin.kafkaPool.Get()
-	defer plugin.httpPool.Put(handler)
-	if handler == nil {
-		plugin.log.Error("plugin get nil handler from pool")
-		return
+// a handler throwing or returning false fails
+func (plugin *Plugin) KafkaHandle(consume KafkaConsume, msg *tbkafka.CGMessage) error {
+	return plugin.kafkaHandle(consume, msg, fmt.Sprintf("topic: %s, group: %s, partition: %d, offset: %d",
+		msg.Topic, msg.Group, msg.Partition, msg.Offset))
+}
+
+// the batch fails as a whole
+func (plugin *Plugin) KafkaBatchHandle(consume KafkaConsume, msgs []*tbkafka.CGMessage) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	first, last := msgs[0], msgs[len(msgs)-1]
+	return plugin.kafkaHandle(consume, msgs, fmt.Sprintf("topic: %s, group: %s, partition: %d, offset: %d-%d",
+		first.Topic, first.Group, first.Partition, first.Offset, last.Offset))
+}
+
+func (plugin *Plugin) kafkaHandle(consume KafkaConsume, arg interface{}, desc string) error {
+	pool, rt, err := plugin.getRuntime()
+	if err != nil {
+		return err
+	}
+	defer plugin.putRuntime(pool, rt)
+
+	c, ok := rt.consumeHandler(consume)
+	if !ok {
+		plugin.log.Errorf("plugin consume: %s %s not found", consume.Topic, consume.Group)
+		return tigerbalm.ErrNoSuchRoute
+	}
+	if c.encoding != engine.EncodingNone {
+		arg, err = encodeMessages(arg, c.encoding)
+		if err != nil {
+			return err
+		}
+	}
+	value, err := rt.call(handlerTimeout(rt.registration), c.handler, arg)
+	if err != nil {
+		plugin.log.Errorf("plugin call err: %s, %s", err, desc)
+		return err
+	}
+	if failed(value) {
+		plugin.log.Errorf("plugin handle failed, %s", desc)
+		return tigerbalm.ErrHandleFailed
+	}
+	return nil
+}
+
+// messages are copied, the originals may still be dead-lettered
+func encodeMessages(arg interface{}, encoding string) (interface{}, error) {
+	switch msgs := arg.(type) {
+	case *tbkafka.CGMessage:
+		encoded := *msgs
+		return &encoded, encoded.EncodePayload(encoding)
+	case []*tbkafka.CGMessage:
+		encoded := make([]*tbkafka.CGMessage, len(msgs))
+		for i, msg := range msgs {
+			copied := *msg
+			if err := copied.EncodePayload(encoding); err != nil {
+				return nil, err
+			}
+			encoded[i] = &copied
+		}
+		return encoded, nil
+	}
+	return arg, nil
+}
+
+// a handler returning false fails
+func failed(value engine.Value) bool {
+	if !value.IsBoolean() {
+		return false
 	}
-	this, err := otto.ToValue(nil)
+	ok, _ := value.ToBoolean()
+	return !ok
+}
+
+func (plugin *Plugin) TimerHandle(schedule TimerSchedule, tick *bus.ContextTimer) {
+	pool, rt, err := plugin.getRuntime()
 	if err != nil {
-		plugin.log.Errorf("plugin to value err: %s", err)
 		return
 	}
-	_, err = handler.(otto.Value).Call(this, msg)
+	defer plugin.putRuntime(pool, rt)
+
+	handler, ok := rt.scheduleHandler(schedule)
+	if !ok {
+		plugin.log.Errorf("plugin schedule: %s %s not found", schedule.Cron, schedule.Interval)
+		return
+	}
+	// times in unix milliseconds, zero means none
+	_, err = rt.call(handlerTimeout(rt.registration), handler, map[string]interface{}{
+		"Time": unixMilli(tick.Time),
+		"Last": unixMilli(tick.Last),
+		"Next": unixMilli(tick.Next),
+	})
 	if err != nil {
-		plugin.log.Errorf("plugin call err: %s", err)
+		plugin.log.Errorf("plugin call err: %s, cron: %s, interval: %s",
+			err, schedule.Cron, schedule.Interval)
 		return
 	}
 }
 
+// a handler throwing or returning false fails, a failed stream message
+// is not acked
+func (plugin *Plugin) RedisHandle(subscribe RedisSubscribe, msg *tbredis.TbMessage) error {
+	pool, rt, err := plugin.getRuntime()
+	if err != nil {
+		return err
+	}
+	defer plugin.putRuntime(pool, rt)
+
+	handler, ok := rt.subscribeHandler(subscribe)
+	if !ok {
+		plugin.log.Errorf("plugin subscribe: %s %s %s not found",
+			subscribe.Kind, subscribe.Key, subscribe.Group)
+		return tigerbalm.ErrNoSuchRoute
+	}
+	value, err := rt.call(handlerTimeout(rt.registration), handler, msg)
+	if err != nil {
+		plugin.log.Errorf("plugin call err: %s, kind: %s, channel: %s, group: %s, id: %s",
+			err, msg.Kind, msg.Channel, msg.Group, msg.ID)
+		return err
+	}
+	if failed(value) {
+		return tigerbalm.ErrHandleFailed
+	}
+	return nil
+}
+
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
 func (plugin *Plugin) Fini() {
+	plugin.destroy()
 	plugin.rotateLog.Close()
 }
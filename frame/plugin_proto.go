@@ -1,9 +1,18 @@
 package frame
 
 import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/jumboframes/tigerbalm"
+	"github.com/jumboframes/tigerbalm/bus"
+	"github.com/jumboframes/tigerbalm/frame/capal/tbredis"
 
-	"github.com/robertkrimen/otto"
+	"github.com/jumboframes/tigerbalm/frame/engine"
+	"github.com/robfig/cron/v3"
 )
 
 const (
@@ -15,10 +24,34 @@ const (
 	MetaTopic   = "topic"
 	MetaGroup   = "group"
 	MetaHandler = "handler"
+	MetaTimeout = "timeout"
+
+	MetaSchedule = "schedule"
+	MetaCron     = "cron"
+	MetaInterval = "interval"
+	MetaOverlap  = "overlap"
+
+	MetaBatch       = "batch"
+	MetaConcurrency = "concurrency"
+	MetaSize        = "size"
+	MetaWindow      = "window"
+	MetaRetry       = "retry"
+	MetaCount       = "count"
+	MetaBackoff     = "backoff"
+	MetaDeadLetter  = "deadletter"
+	MetaEncoding    = "encoding"
+
+	MetaSubscribe = "subscribe"
+	MetaStream    = "stream"
+	MetaChannel   = "channel"
+	MetaPattern   = "pattern"
+	MetaKey       = "key"
 )
 
 const (
 	FuncRegister = "register"
+	FuncInit     = "init"
+	FuncDestroy  = "destroy"
 	FuncRequire  = "require"
 	VarContext   = "context"
 )
@@ -29,25 +62,200 @@ type context struct {
 
 type runtime struct {
 	*registration
-	vm *otto.Otto
+	vm engine.VM
+	// a broken runtime may carry a pending interrupt, must not be reused
+	broken bool
+	// deadline of the running call, never extended beyond limit
+	mu      sync.Mutex
+	timer   *time.Timer
+	timeout time.Duration
+	limit   time.Time
+}
+
+// call calls fn with a deadline, the vm is interrupted if the deadline
+// exceeds and the runtime is marked as broken.
+func (runtime *runtime) call(timeout time.Duration, fn engine.Value,
+	args ...interface{}) (engine.Value, error) {
+	return runtime.callStream(timeout, 0, nil, fn, args...)
+}
+
+// run runs the program with a deadline like call
+func (runtime *runtime) run(timeout time.Duration, program engine.Program) error {
+	if timeout <= 0 {
+		return runtime.vm.Run(program)
+	}
+
+	timer := time.AfterFunc(timeout, runtime.vm.Interrupt)
+	err := runtime.vm.Run(program)
+	if !timer.Stop() {
+		runtime.broken = true
+	}
+	if err == engine.ErrInterrupted {
+		return tigerbalm.ErrTimeout
+	}
+	return err
+}
+
+// callStream calls fn like call, besides the deadline is no later than limit
+// after the start however it's extended, and the vm is interrupted as done
+// is closed.
+func (runtime *runtime) callStream(timeout, limit time.Duration, done <-chan struct{},
+	fn engine.Value, args ...interface{}) (engine.Value, error) {
+
+	if timeout <= 0 && limit <= 0 {
+		return runtime.vm.Call(fn, args...)
+	}
+
+	runtime.mu.Lock()
+	runtime.timeout, runtime.limit = timeout, time.Time{}
+	if limit > 0 {
+		runtime.limit = time.Now().Add(limit)
+	}
+	timer := time.AfterFunc(runtime.left(), runtime.vm.Interrupt)
+	runtime.timer = timer
+	runtime.mu.Unlock()
+	if done != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-done:
+				runtime.cancel()
+			case <-stop:
+			}
+		}()
+	}
+	value, err := runtime.vm.Call(fn, args...)
+	runtime.mu.Lock()
+	runtime.timer = nil
+	stopped := timer.Stop()
+	runtime.mu.Unlock()
+	if !stopped {
+		runtime.broken = true
+	}
+	if err == engine.ErrInterrupted {
+		return nil, tigerbalm.ErrTimeout
+	}
+	return value, err
+}
+
+// left is the timeout, or what's left to the limit if sooner
+func (runtime *runtime) left() time.Duration {
+	if runtime.limit.IsZero() {
+		return runtime.timeout
+	}
+	left := time.Until(runtime.limit)
+	if runtime.timeout > 0 && runtime.timeout < left {
+		return runtime.timeout
+	}
+	return left
+}
+
+// extend pushes the deadline of the running call back by its timeout, a
+// call already interrupted is not extended
+func (runtime *runtime) extend() {
+	runtime.mu.Lock()
+	defer runtime.mu.Unlock()
+	if runtime.timer != nil && runtime.timeout > 0 && runtime.timer.Stop() {
+		runtime.timer.Reset(runtime.left())
+	}
+}
+
+// cancel interrupts the running call now
+func (runtime *runtime) cancel() {
+	runtime.mu.Lock()
+	defer runtime.mu.Unlock()
+	if runtime.timer != nil && runtime.timer.Stop() {
+		runtime.timer.Reset(0)
+	}
+}
+
+// hooks are optional global functions, undefined ones are skipped
+func (runtime *runtime) callHook(timeout time.Duration, name string) error {
+	hook, err := runtime.vm.Get(name)
+	if err != nil {
+		return err
+	}
+	if !hook.IsFunction() {
+		return nil
+	}
+	_, err = runtime.call(timeout, hook)
+	return err
+}
+
+func (runtime *runtime) routeHandler(match HttpRoute) (*route, bool) {
+	for _, route := range runtime.routes {
+		if route.path == match.Path && route.method == match.Method {
+			return route, true
+		}
+	}
+	return nil, false
+}
+
+func (runtime *runtime) consumeHandler(match KafkaConsume) (*consume, bool) {
+	for _, consume := range runtime.consumes {
+		if consume.topic == match.Topic && consume.group == match.Group &&
+			consume.batch == match.Batch && consume.window == match.Window &&
+			consume.concurrency == match.Concurrency {
+			return consume, true
+		}
+	}
+	return nil, false
+}
+
+func (runtime *runtime) scheduleHandler(match TimerSchedule) (engine.Value, bool) {
+	for _, schedule := range runtime.schedules {
+		if schedule.TimerSchedule == match {
+			return schedule.handler, true
+		}
+	}
+	return nil, false
+}
+
+func (runtime *runtime) subscribeHandler(match RedisSubscribe) (engine.Value, bool) {
+	for _, subscribe := range runtime.subscribes {
+		if subscribe.RedisSubscribe == match {
+			return subscribe.handler, true
+		}
+	}
+	return nil, false
 }
 
 type registration struct {
-	route   *route
-	consume *consume
+	routes     []*route
+	consumes   []*consume
+	schedules  []*schedule
+	subscribes []*subscribe
+	timeout    time.Duration // optional, zero means default
 }
 
 type consume struct {
 	topic, group string
-	handler      otto.Value
+	batch        int
+	window       time.Duration
+	concurrency  int
+	policy       ConsumePolicy
+	encoding     string
+	handler      engine.Value
+}
+
+type schedule struct {
+	TimerSchedule
+	handler engine.Value
+}
+
+type subscribe struct {
+	RedisSubscribe
+	handler engine.Value
 }
 
 type route struct {
 	path, method string
-	handler      otto.Value
+	encoding     string
+	handler      engine.Value
 }
 
-func getRegistration(obj *otto.Object) (*registration, error) {
+func getRegistration(obj engine.Value) (*registration, error) {
 	registration := &registration{}
 
 	routeValue, err := obj.Get(MetaRoute)
@@ -55,33 +263,110 @@ func getRegistration(obj *otto.Object) (*registration, error) {
 		return nil, err
 	}
 	if routeValue.IsDefined() {
-		route, err := getRoute(routeValue.Object())
+		routes, err := getRoutes(routeValue)
 		if err != nil {
 			return nil, err
 		}
-		registration.route = route
+		registration.routes = routes
 	}
 	consumeValue, err := obj.Get(MetaConsume)
 	if err != nil {
 		return nil, err
 	}
 	if consumeValue.IsDefined() {
-		consume, err := getConsume(consumeValue.Object())
+		consumes, err := getConsumes(consumeValue)
+		if err != nil {
+			return nil, err
+		}
+		registration.consumes = consumes
+	}
+	scheduleValue, err := obj.Get(MetaSchedule)
+	if err != nil {
+		return nil, err
+	}
+	if scheduleValue.IsDefined() {
+		schedules, err := getSchedules(scheduleValue)
+		if err != nil {
+			return nil, err
+		}
+		registration.schedules = schedules
+	}
+	subscribeValue, err := obj.Get(MetaSubscribe)
+	if err != nil {
+		return nil, err
+	}
+	if subscribeValue.IsDefined() {
+		subscribes, err := getSubscribes(subscribeValue, getSubscribe)
+		if err != nil {
+			return nil, err
+		}
+		registration.subscribes = append(registration.subscribes, subscribes...)
+	}
+	streamValue, err := obj.Get(MetaStream)
+	if err != nil {
+		return nil, err
+	}
+	if streamValue.IsDefined() {
+		subscribes, err := getSubscribes(streamValue, getStream)
+		if err != nil {
+			return nil, err
+		}
+		registration.subscribes = append(registration.subscribes, subscribes...)
+	}
+	// timeout in milliseconds
+	timeoutValue, err := obj.Get(MetaTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if timeoutValue.IsDefined() {
+		timeout, err := timeoutValue.ToInteger()
 		if err != nil {
 			return nil, err
 		}
-		registration.consume = consume
+		registration.timeout = time.Duration(timeout) * time.Millisecond
 	}
 	return registration, nil
 }
 
-func getConsume(obj *otto.Object) (*consume, error) {
+// consume can be a single object or an array of objects
+func getConsumes(obj engine.Value) ([]*consume, error) {
+	if !obj.IsArray() {
+		c, err := getConsume(obj)
+		if err != nil {
+			return nil, err
+		}
+		return []*consume{c}, nil
+	}
+	consumes := []*consume{}
+	for _, key := range obj.Keys() {
+		consumeValue, err := obj.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if !consumeValue.IsObject() {
+			return nil, tigerbalm.ErrRegisterNotObject
+		}
+		c, err := getConsume(consumeValue)
+		if err != nil {
+			return nil, err
+		}
+		for _, registered := range consumes {
+			if registered.topic == c.topic && registered.group == c.group {
+				return nil, tigerbalm.ErrRegisterDupConsume
+			}
+		}
+		consumes = append(consumes, c)
+	}
+	return consumes, nil
+}
+
+func getConsume(obj engine.Value) (*consume, error) {
 	matchValue, err := obj.Get(MetaMatch)
 	if err != nil {
 		return nil, err
 	}
 	// topic
-	topicValue, err := matchValue.Object().Get(MetaTopic)
+	topicValue, err := matchValue.Get(MetaTopic)
 	if err != nil {
 		return nil, err
 	}
@@ -90,7 +375,7 @@ func getConsume(obj *otto.Object) (*consume, error) {
 		return nil, err
 	}
 	// group
-	groupValue, err := matchValue.Object().Get(MetaGroup)
+	groupValue, err := matchValue.Get(MetaGroup)
 	if err != nil {
 		return nil, err
 	}
@@ -106,21 +391,192 @@ func getConsume(obj *otto.Object) (*consume, error) {
 	if !handler.IsFunction() {
 		return nil, tigerbalm.ErrRegisterNotFunction
 	}
+	batch, window, err := getConsumeBatch(obj)
+	if err != nil {
+		return nil, err
+	}
+	concurrency, err := getConsumeConcurrency(obj)
+	if err != nil {
+		return nil, err
+	}
+	if batch > 0 && concurrency > 1 {
+		return nil, tigerbalm.ErrRegisterBatchConcurrency
+	}
+	policy, err := getConsumePolicy(obj)
+	if err != nil {
+		return nil, err
+	}
+	encoding, err := getEncoding(obj)
+	if err != nil {
+		return nil, err
+	}
 	consume := &consume{
-		topic:   topic,
-		group:   group,
-		handler: handler,
+		topic:       topic,
+		group:       group,
+		batch:       batch,
+		window:      window,
+		concurrency: concurrency,
+		policy:      policy,
+		encoding:    encoding,
+		handler:     handler,
 	}
 	return consume, nil
 }
 
-func getRoute(obj *otto.Object) (*route, error) {
+// batch is optional, window in milliseconds
+func getConsumeBatch(obj engine.Value) (int, time.Duration, error) {
+	batchValue, err := obj.Get(MetaBatch)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !batchValue.IsDefined() {
+		return 0, 0, nil
+	}
+	sizeValue, err := batchValue.Get(MetaSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	size, err := sizeValue.ToInteger()
+	if err != nil {
+		return 0, 0, err
+	}
+	if size <= 0 {
+		return 0, 0, tigerbalm.ErrRegisterBadBatch
+	}
+	window := time.Duration(0)
+	windowValue, err := batchValue.Get(MetaWindow)
+	if err != nil {
+		return 0, 0, err
+	}
+	if windowValue.IsDefined() {
+		ms, err := windowValue.ToInteger()
+		if err != nil {
+			return 0, 0, err
+		}
+		window = time.Duration(ms) * time.Millisecond
+	}
+	return int(size), window, nil
+}
+
+// concurrency is optional, 1 by default
+func getConsumeConcurrency(obj engine.Value) (int, error) {
+	concurrencyValue, err := obj.Get(MetaConcurrency)
+	if err != nil {
+		return 0, err
+	}
+	if !concurrencyValue.IsDefined() {
+		return 1, nil
+	}
+	concurrency, err := concurrencyValue.ToInteger()
+	if err != nil {
+		return 0, err
+	}
+	if concurrency <= 0 {
+		return 0, tigerbalm.ErrRegisterBadConcurrency
+	}
+	return int(concurrency), nil
+}
+
+// retry and deadletter are optional, backoff in milliseconds
+func getConsumePolicy(obj engine.Value) (ConsumePolicy, error) {
+	policy := ConsumePolicy{}
+	retryValue, err := obj.Get(MetaRetry)
+	if err != nil {
+		return policy, err
+	}
+	if retryValue.IsDefined() {
+		countValue, err := retryValue.Get(MetaCount)
+		if err != nil {
+			return policy, err
+		}
+		count, err := countValue.ToInteger()
+		if err != nil {
+			return policy, err
+		}
+		policy.Retry = int(count)
+		backoffValue, err := retryValue.Get(MetaBackoff)
+		if err != nil {
+			return policy, err
+		}
+		if backoffValue.IsDefined() {
+			backoff, err := backoffValue.ToInteger()
+			if err != nil {
+				return policy, err
+			}
+			policy.Backoff = time.Duration(backoff) * time.Millisecond
+		}
+	}
+	deadLetterValue, err := obj.Get(MetaDeadLetter)
+	if err != nil {
+		return policy, err
+	}
+	if deadLetterValue.IsDefined() {
+		policy.DeadLetter, err = deadLetterValue.ToString()
+		if err != nil {
+			return policy, err
+		}
+	}
+	return policy, nil
+}
+
+// route can be a single object or an array of objects
+func getRoutes(obj engine.Value) ([]*route, error) {
+	if !obj.IsArray() {
+		r, err := getRoute(obj)
+		if err != nil {
+			return nil, err
+		}
+		return []*route{r}, nil
+	}
+	routes := []*route{}
+	for _, key := range obj.Keys() {
+		routeValue, err := obj.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if !routeValue.IsObject() {
+			return nil, tigerbalm.ErrRegisterNotObject
+		}
+		r, err := getRoute(routeValue)
+		if err != nil {
+			return nil, err
+		}
+		for _, registered := range routes {
+			if normalizePath(registered.path) == normalizePath(r.path) &&
+				registered.method == r.method {
+				return nil, tigerbalm.ErrRegisterDupRoute
+			}
+		}
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+var pathParam = regexp.MustCompile(`\{[^}]*\}`)
+
+// normalizePath drops names of path parameters, /users/{id} and
+// /users/{uid:string} are the same route to iris
+func normalizePath(path string) string {
+	return pathParam.ReplaceAllStringFunc(path, func(param string) string {
+		macro := "string"
+		if index := strings.Index(param, ":"); index >= 0 {
+			macro = strings.TrimSpace(param[index+1 : len(param)-1])
+		}
+		return "{:" + macro + "}"
+	})
+}
+
+func routeKey(route HttpRoute) string {
+	return route.Method + " " + normalizePath(route.Path)
+}
+
+func getRoute(obj engine.Value) (*route, error) {
 	matchValue, err := obj.Get(MetaMatch)
 	if err != nil {
 		return nil, err
 	}
 	// path
-	urlValue, err := matchValue.Object().Get(MetaPath)
+	urlValue, err := matchValue.Get(MetaPath)
 	if err != nil {
 		return nil, err
 	}
@@ -129,7 +585,7 @@ func getRoute(obj *otto.Object) (*route, error) {
 		return nil, err
 	}
 	// method
-	methodValue, err := matchValue.Object().Get(MetaMethod)
+	methodValue, err := matchValue.Get(MetaMethod)
 	if err != nil {
 		return nil, err
 	}
@@ -145,10 +601,235 @@ func getRoute(obj *otto.Object) (*route, error) {
 	if !handler.IsFunction() {
 		return nil, tigerbalm.ErrRegisterNotFunction
 	}
+	encoding, err := getEncoding(obj)
+	if err != nil {
+		return nil, err
+	}
 	route := &route{
-		path:    path,
-		method:  method,
-		handler: handler,
+		path:     path,
+		method:   method,
+		encoding: encoding,
+		handler:  handler,
 	}
 	return route, nil
 }
+
+// encoding is optional, bodies and payloads passed to the handler are
+// encoded if set
+func getEncoding(obj engine.Value) (string, error) {
+	encodingValue, err := obj.Get(MetaEncoding)
+	if err != nil {
+		return "", err
+	}
+	if !encodingValue.IsDefined() {
+		return engine.EncodingNone, nil
+	}
+	encoding, err := encodingValue.ToString()
+	if err != nil {
+		return "", err
+	}
+	if encoding != engine.EncodingNone && encoding != engine.EncodingBase64 {
+		return "", tigerbalm.ErrRegisterBadEncoding
+	}
+	return encoding, nil
+}
+
+// schedule can be a single object or an array of objects
+func getSchedules(obj engine.Value) ([]*schedule, error) {
+	if !obj.IsArray() {
+		s, err := getSchedule(obj)
+		if err != nil {
+			return nil, err
+		}
+		return []*schedule{s}, nil
+	}
+	schedules := []*schedule{}
+	for _, key := range obj.Keys() {
+		scheduleValue, err := obj.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if !scheduleValue.IsObject() {
+			return nil, tigerbalm.ErrRegisterNotObject
+		}
+		s, err := getSchedule(scheduleValue)
+		if err != nil {
+			return nil, err
+		}
+		for _, registered := range schedules {
+			if sameSchedule(registered.TimerSchedule, s.TimerSchedule) {
+				return nil, tigerbalm.ErrRegisterDupSchedule
+			}
+		}
+		schedules = append(schedules, s)
+	}
+	return schedules, nil
+}
+
+func getSchedule(obj engine.Value) (*schedule, error) {
+	s := &schedule{}
+	// cron
+	cronValue, err := obj.Get(MetaCron)
+	if err != nil {
+		return nil, err
+	}
+	if cronValue.IsDefined() {
+		s.Cron, err = cronValue.ToString()
+		if err != nil {
+			return nil, err
+		}
+		// the timer drops a schedule it can't parse, fail the loading instead
+		if _, err = cron.ParseStandard(s.Cron); err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", tigerbalm.ErrRegisterBadCron, s.Cron, err)
+		}
+	}
+	// interval in milliseconds
+	intervalValue, err := obj.Get(MetaInterval)
+	if err != nil {
+		return nil, err
+	}
+	if intervalValue.IsDefined() {
+		interval, err := intervalValue.ToInteger()
+		if err != nil {
+			return nil, err
+		}
+		s.Interval = time.Duration(interval) * time.Millisecond
+	}
+	if s.Cron == "" && s.Interval <= 0 {
+		return nil, tigerbalm.ErrRegisterNoSchedule
+	}
+	// overlap
+	overlapValue, err := obj.Get(MetaOverlap)
+	if err != nil {
+		return nil, err
+	}
+	s.Overlap = bus.OverlapSkip
+	if overlapValue.IsDefined() {
+		s.Overlap, err = overlapValue.ToString()
+		if err != nil {
+			return nil, err
+		}
+		if s.Overlap != bus.OverlapSkip && s.Overlap != bus.OverlapQueue {
+			return nil, tigerbalm.ErrRegisterBadOverlap
+		}
+	}
+	// handler
+	handler, err := obj.Get(MetaHandler)
+	if err != nil {
+		return nil, err
+	}
+	if !handler.IsFunction() {
+		return nil, tigerbalm.ErrRegisterNotFunction
+	}
+	s.handler = handler
+	return s, nil
+}
+
+// schedules are the same timer entry if their cron, or their interval
+// rounded to seconds, and their overlap are the same
+func sameSchedule(a, b TimerSchedule) bool {
+	if a.Overlap != b.Overlap || a.Cron != b.Cron {
+		return false
+	}
+	if a.Cron != "" {
+		return true
+	}
+	return cron.Every(a.Interval).Delay == cron.Every(b.Interval).Delay
+}
+
+// subscribe and stream can be a single object or an array of objects
+func getSubscribes(obj engine.Value,
+	get func(engine.Value) (*subscribe, error)) ([]*subscribe, error) {
+
+	if !obj.IsArray() {
+		s, err := get(obj)
+		if err != nil {
+			return nil, err
+		}
+		return []*subscribe{s}, nil
+	}
+	subscribes := []*subscribe{}
+	for _, key := range obj.Keys() {
+		subscribeValue, err := obj.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if !subscribeValue.IsObject() {
+			return nil, tigerbalm.ErrRegisterNotObject
+		}
+		s, err := get(subscribeValue)
+		if err != nil {
+			return nil, err
+		}
+		subscribes = append(subscribes, s)
+	}
+	return subscribes, nil
+}
+
+// a subscribe has either a channel or a pattern
+func getSubscribe(obj engine.Value) (*subscribe, error) {
+	s := &subscribe{}
+	channelValue, err := obj.Get(MetaChannel)
+	if err != nil {
+		return nil, err
+	}
+	patternValue, err := obj.Get(MetaPattern)
+	if err != nil {
+		return nil, err
+	}
+	if channelValue.IsDefined() {
+		s.Kind = tbredis.KindSubscribe
+		s.Key, err = channelValue.ToString()
+	} else if patternValue.IsDefined() {
+		s.Kind = tbredis.KindPSubscribe
+		s.Key, err = patternValue.ToString()
+	} else {
+		return nil, tigerbalm.ErrRegisterNoChannel
+	}
+	if err != nil {
+		return nil, err
+	}
+	// handler
+	handler, err := obj.Get(MetaHandler)
+	if err != nil {
+		return nil, err
+	}
+	if !handler.IsFunction() {
+		return nil, tigerbalm.ErrRegisterNotFunction
+	}
+	s.handler = handler
+	return s, nil
+}
+
+func getStream(obj engine.Value) (*subscribe, error) {
+	s := &subscribe{}
+	s.Kind = tbredis.KindStream
+	// key
+	keyValue, err := obj.Get(MetaKey)
+	if err != nil {
+		return nil, err
+	}
+	s.Key, err = keyValue.ToString()
+	if err != nil {
+		return nil, err
+	}
+	// group
+	groupValue, err := obj.Get(MetaGroup)
+	if err != nil {
+		return nil, err
+	}
+	s.Group, err = groupValue.ToString()
+	if err != nil {
+		return nil, err
+	}
+	// handler
+	handler, err := obj.Get(MetaHandler)
+	if err != nil {
+		return nil, err
+	}
+	if !handler.IsFunction() {
+		return nil, tigerbalm.ErrRegisterNotFunction
+	}
+	s.handler = handler
+	return s, nil
+}
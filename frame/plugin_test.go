@@ -0,0 +1,223 @@
+package frame
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jumboframes/tigerbalm"
+	"github.com/jumboframes/tigerbalm/frame/capal"
+	"github.com/jumboframes/tigerbalm/frame/capal/tbhttp"
+	"github.com/jumboframes/tigerbalm/frame/capal/tbkafka"
+	"github.com/jumboframes/tigerbalm/frame/capal/tblog"
+	"github.com/jumboframes/tigerbalm/frame/capal/tbredis"
+	"github.com/jumboframes/tigerbalm/frame/engine"
+)
+
+var engines = []string{engine.EngineOtto, engine.EngineGoja}
+
+// syncBuffer is written by runtimes of a pool concurrently
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (sb *syncBuffer) Write(p []byte) (int, error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.buf.Write(p)
+}
+
+func (sb *syncBuffer) count(s string) int {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return strings.Count(sb.buf.String(), s)
+}
+
+// setConf sets a config of the engine, plugin logs go to a temporary dir
+func setConf(t *testing.T, eng string, minIdle int) *tigerbalm.Config {
+	conf := &tigerbalm.Config{}
+	conf.Plugin.Engine = eng
+	conf.Plugin.Timeout = 1
+	conf.Plugin.Log.Level = "info"
+	conf.Plugin.Log.Path = t.TempDir()
+	conf.Plugin.Pool.MinIdle = &minIdle
+	tigerbalm.Conf = conf
+	return conf
+}
+
+// newTestCapal logs all plugins to out, producer may fail the require
+func newTestCapal(out io.Writer,
+	producer func() (*tbkafka.TbProducer, error)) *capal.Capal {
+
+	log := tblog.NewTbLog().WithLevel(tblog.LevelInfo).WithOutput(out)
+	return capal.NewCapal(
+		func(*capal.PluginContext) *tbhttp.TbHttp { return &tbhttp.TbHttp{} },
+		func(*capal.PluginContext) *tblog.TbLog { return log },
+		func(*capal.PluginContext) *tbredis.TbRedis { return nil },
+		func(*capal.PluginContext) (*tbkafka.TbProducer, error) { return producer() })
+}
+
+func newTestPlugin(t *testing.T, src string, cpl *capal.Capal) *Plugin {
+	plugin, err := NewPlugin("test", []byte(src), cpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plugin
+}
+
+const hookSrc = `
+function register() {
+	return {"route": {"match": {"path": "/a", "method": "GET"}, "handler": function() {}}}
+}
+function init() {
+	if (!require("producer")) {
+		throw "no producer"
+	}
+	require("log").Info("init")
+}
+function destroy() {
+	require("log").Info("destroy")
+}`
+
+func TestPluginHooks(t *testing.T) {
+	for _, name := range engines {
+		setConf(t, name, 2)
+		out := &syncBuffer{}
+		plugin := newTestPlugin(t, hookSrc, newTestCapal(out,
+			func() (*tbkafka.TbProducer, error) { return &tbkafka.TbProducer{}, nil }))
+
+		// init runs in every runtime, destroy in every runtime of the old
+		// version after reloading and of the last version after unloading
+		if err := plugin.Load(); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if err := plugin.Reload([]byte(hookSrc)); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if out.count("INFO  init") != 4 || out.count("INFO  destroy") != 2 {
+			t.Errorf("%s: unexpected hooks after reloading: %s", name, out.buf.String())
+		}
+		plugin.Fini()
+		if out.count("INFO  init") != 4 || out.count("INFO  destroy") != 4 {
+			t.Errorf("%s: unexpected hooks after unloading: %s", name, out.buf.String())
+		}
+	}
+}
+
+func TestPluginWarmFail(t *testing.T) {
+	for _, name := range engines {
+		setConf(t, name, 3)
+		out := &syncBuffer{}
+		producers := 0
+		plugin := newTestPlugin(t, hookSrc, newTestCapal(out,
+			func() (*tbkafka.TbProducer, error) {
+				producers++
+				if producers > 2 {
+					return nil, errors.New("no producer")
+				}
+				return &tbkafka.TbProducer{}, nil
+			}))
+
+		// the runtimes initialized before the failed one are destroyed
+		if err := plugin.Load(); err == nil {
+			t.Errorf("%s: unexpected loaded", name)
+		}
+		if out.count("INFO  init") != 2 || out.count("INFO  destroy") != 2 {
+			t.Errorf("%s: unexpected hooks: %s", name, out.buf.String())
+		}
+		if stats := plugin.PoolStats(); stats.Size != 0 {
+			t.Errorf("%s: unexpected stats: %+v", name, stats)
+		}
+		plugin.Fini()
+	}
+}
+
+func TestPluginLoadErr(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  error
+	}{{
+		name: "program timeout",
+		src:  `while (true) {}`,
+		err:  tigerbalm.ErrTimeout,
+	}, {
+		name: "register timeout",
+		src:  `function register() { while (true) {} }`,
+		err:  tigerbalm.ErrTimeout,
+	}, {
+		name: "duplicate route",
+		src: `function register() {
+			return {"route": [
+				{"match": {"path": "/a", "method": "GET"}, "handler": function() {}},
+				{"match": {"path": "/a", "method": "GET"}, "handler": function() {}}
+			]}
+		}`,
+		err: tigerbalm.ErrRegisterDupRoute,
+	}, {
+		name: "duplicate consume",
+		src: `function register() {
+			return {"consume": [
+				{"match": {"topic": "a", "group": "g"}, "handler": function() {}},
+				{"match": {"topic": "a", "group": "g"}, "handler": function() {}}
+			]}
+		}`,
+		err: tigerbalm.ErrRegisterDupConsume,
+	}, {
+		name: "bad cron",
+		src: `function register() {
+			return {"schedule": {"cron": "*/5 * *", "handler": function() {}}}
+		}`,
+		err: tigerbalm.ErrRegisterBadCron,
+	}, {
+		name: "duplicate schedule",
+		src: `function register() {
+			return {"schedule": [
+				{"interval": 1000, "handler": function() {}},
+				{"interval": 1200, "handler": function() {}}
+			]}
+		}`,
+		err: tigerbalm.ErrRegisterDupSchedule,
+	}}
+	for _, name := range engines {
+		for _, test := range tests {
+			setConf(t, name, 1)
+			plugin := newTestPlugin(t, test.src, nil)
+			if err := plugin.Load(); !errors.Is(err, test.err) {
+				t.Errorf("%s %s: unexpected err: %v", name, test.name, err)
+			}
+			plugin.Fini()
+		}
+	}
+}
+
+func TestPluginHandleTimeout(t *testing.T) {
+	for _, name := range engines {
+		setConf(t, name, 1)
+		plugin := newTestPlugin(t, `function register() {
+			return {"timeout": 50, "route": {"match": {"path": "/a", "method": "GET"},
+				"handler": function() { while (true) {} }}}
+		}`, nil)
+		if err := plugin.Load(); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		route := HttpRoute{Path: "/a", Method: "GET"}
+		w := tbhttp.NewResponseWriter(nil, nil)
+		if _, err := plugin.HttpHandle(route, &tbhttp.Request{}, w); err != tigerbalm.ErrTimeout {
+			t.Errorf("%s: unexpected err: %v", name, err)
+		}
+
+		// the interrupted runtime is dropped and the pool refilled
+		pool, rt, err := plugin.getRuntime()
+		if err != nil || rt.broken {
+			t.Errorf("%s: unexpected runtime, err: %v", name, err)
+		} else {
+			plugin.putRuntime(pool, rt)
+		}
+		plugin.Fini()
+	}
+}
@@ -0,0 +1,217 @@
+package frame
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jumboframes/tigerbalm"
+)
+
+const (
+	defaultPoolMinIdle     = 1
+	defaultPoolMaxSize     = 32
+	defaultPoolWaitTimeout = 3 * time.Second
+)
+
+// a retired pool belongs to a replaced or unloaded version, runtimes of
+// the current version should be taken instead
+var errPoolRetired = errors.New("pool retired")
+
+type PoolStats struct {
+	Size  int // runtimes created and not yet dropped
+	Idle  int
+	InUse int
+}
+
+// vmPool is a bounded runtime pool, the total runtimes never exceed
+// maxSize, callers wait for an idle one until waitTimeout when exhausted.
+type vmPool struct {
+	factory     func() (*runtime, error)
+	minIdle     int
+	waitTimeout time.Duration
+
+	tokens chan struct{} // one token for one created runtime
+	idle   chan *runtime
+
+	// creating and putting runtimes hold the read lock, so no runtime is
+	// made or kept idle after retiring
+	mu      sync.RWMutex
+	retired chan struct{}
+}
+
+func newVMPool(factory func() (*runtime, error),
+	minIdle, maxSize int, waitTimeout time.Duration) *vmPool {
+
+	if maxSize <= 0 {
+		maxSize = defaultPoolMaxSize
+	}
+	if minIdle < 0 {
+		minIdle = 0
+	}
+	if minIdle > maxSize {
+		minIdle = maxSize
+	}
+	if waitTimeout <= 0 {
+		waitTimeout = defaultPoolWaitTimeout
+	}
+	return &vmPool{
+		factory:     factory,
+		minIdle:     minIdle,
+		waitTimeout: waitTimeout,
+		tokens:      make(chan struct{}, maxSize),
+		idle:        make(chan *runtime, maxSize),
+		retired:     make(chan struct{}),
+	}
+}
+
+func newVMPoolFromConf(factory func() (*runtime, error)) *vmPool {
+	conf := tigerbalm.Conf.Plugin.Pool
+	minIdle := defaultPoolMinIdle
+	if conf.MinIdle != nil {
+		minIdle = *conf.MinIdle
+	}
+	return newVMPool(factory, minIdle, conf.MaxSize,
+		conf.WaitTimeout*time.Second)
+}
+
+// add puts a runtime created outside into the pool
+func (pool *vmPool) add(rt *runtime) bool {
+	select {
+	case pool.tokens <- struct{}{}:
+		pool.idle <- rt
+		return true
+	default:
+		return false
+	}
+}
+
+// warm creates runtimes until minIdle are idle or the pool is full
+func (pool *vmPool) warm() error {
+	for len(pool.idle) < pool.minIdle {
+		select {
+		case pool.tokens <- struct{}{}:
+		default:
+			return nil
+		}
+		rt, err := pool.create()
+		if err != nil {
+			return err
+		}
+		if !pool.put(rt) {
+			return errPoolRetired
+		}
+	}
+	return nil
+}
+
+// create makes a runtime for a token taken, the token is given back if
+// it fails
+func (pool *vmPool) create() (*runtime, error) {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	if pool.isRetired() {
+		<-pool.tokens
+		return nil, errPoolRetired
+	}
+	rt, err := pool.factory()
+	if err != nil {
+		<-pool.tokens
+		return nil, err
+	}
+	return rt, nil
+}
+
+func (pool *vmPool) get() (*runtime, error) {
+	if pool.isRetired() {
+		return nil, errPoolRetired
+	}
+	select {
+	case rt := <-pool.idle:
+		return rt, nil
+	default:
+	}
+
+	timer := time.NewTimer(pool.waitTimeout)
+	defer timer.Stop()
+	select {
+	case rt := <-pool.idle:
+		return rt, nil
+	case pool.tokens <- struct{}{}:
+		rt, err := pool.create()
+		if err == errPoolRetired {
+			return nil, err
+		}
+		if err != nil {
+			return nil, tigerbalm.ErrNewInterpreter
+		}
+		return rt, nil
+	case <-pool.retired:
+		return nil, errPoolRetired
+	case <-timer.C:
+		return nil, tigerbalm.ErrPoolExhausted
+	}
+}
+
+// put returns false if the pool is retired, the runtime is thrown away
+func (pool *vmPool) put(rt *runtime) bool {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	if pool.isRetired() {
+		<-pool.tokens
+		return false
+	}
+	pool.idle <- rt
+	return true
+}
+
+// drop throws away a runtime and gives the room back to the pool, a
+// retired pool is not refilled
+func (pool *vmPool) drop(rt *runtime) {
+	<-pool.tokens
+	if !pool.isRetired() {
+		go pool.warm()
+	}
+}
+
+// retire stops making runtimes, idle runtimes are thrown away and
+// returned, runtimes in use are thrown away as they are put
+func (pool *vmPool) retire() []*runtime {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if pool.isRetired() {
+		return nil
+	}
+	close(pool.retired)
+	rts := []*runtime{}
+	for {
+		select {
+		case rt := <-pool.idle:
+			<-pool.tokens
+			rts = append(rts, rt)
+		default:
+			return rts
+		}
+	}
+}
+
+func (pool *vmPool) isRetired() bool {
+	select {
+	case <-pool.retired:
+		return true
+	default:
+		return false
+	}
+}
+
+func (pool *vmPool) stats() PoolStats {
+	size, idle := len(pool.tokens), len(pool.idle)
+	return PoolStats{
+		Size:  size,
+		Idle:  idle,
+		InUse: size - idle,
+	}
+}
@@ -0,0 +1,137 @@
+package frame
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jumboframes/tigerbalm"
+)
+
+// newTestPool makes a pool of empty runtimes, created counts them
+func newTestPool(minIdle, maxSize int, waitTimeout time.Duration) (*vmPool, *int32) {
+	created := new(int32)
+	pool := newVMPool(func() (*runtime, error) {
+		atomic.AddInt32(created, 1)
+		return &runtime{}, nil
+	}, minIdle, maxSize, waitTimeout)
+	return pool, created
+}
+
+func TestVMPoolWarm(t *testing.T) {
+	tests := []struct {
+		name    string
+		minIdle int
+		maxSize int
+		idle    int
+	}{{
+		name:    "min idle",
+		minIdle: 2,
+		maxSize: 4,
+		idle:    2,
+	}, {
+		name:    "no idle",
+		minIdle: 0,
+		maxSize: 4,
+		idle:    0,
+	}, {
+		name:    "min idle beyond max size",
+		minIdle: 8,
+		maxSize: 4,
+		idle:    4,
+	}}
+	for _, test := range tests {
+		pool, created := newTestPool(test.minIdle, test.maxSize, time.Second)
+		if err := pool.warm(); err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		stats := pool.stats()
+		if stats.Idle != test.idle || stats.Size != test.idle ||
+			int(atomic.LoadInt32(created)) != test.idle {
+			t.Errorf("%s: unexpected stats: %+v", test.name, stats)
+		}
+	}
+}
+
+func TestVMPoolExhausted(t *testing.T) {
+	pool, _ := newTestPool(0, 1, 50*time.Millisecond)
+	rt, err := pool.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := pool.stats(); stats.Size != 1 || stats.InUse != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// waits for the wait timeout, then fails
+	start := time.Now()
+	if _, err = pool.get(); err != tigerbalm.ErrPoolExhausted {
+		t.Errorf("unexpected err: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("unexpected wait: %s", elapsed)
+	}
+
+	// a waiter is served by the runtime put
+	time.AfterFunc(10*time.Millisecond, func() { pool.put(rt) })
+	got, err := pool.get()
+	if err != nil || got != rt {
+		t.Errorf("unexpected runtime: %p, err: %v", got, err)
+	}
+}
+
+func TestVMPoolDrop(t *testing.T) {
+	pool, created := newTestPool(1, 1, time.Second)
+	if err := pool.warm(); err != nil {
+		t.Fatal(err)
+	}
+	rt, err := pool.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the room of a dropped runtime is refilled
+	pool.drop(rt)
+	got, err := pool.get()
+	if err != nil || got == rt {
+		t.Errorf("unexpected runtime: %p, err: %v", got, err)
+	}
+	if atomic.LoadInt32(created) != 2 {
+		t.Errorf("unexpected created: %d", atomic.LoadInt32(created))
+	}
+}
+
+func TestVMPoolRetire(t *testing.T) {
+	pool, created := newTestPool(2, 4, time.Second)
+	if err := pool.warm(); err != nil {
+		t.Fatal(err)
+	}
+	inUse, err := pool.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// idle runtimes are returned to be destroyed
+	if rts := pool.retire(); len(rts) != 1 {
+		t.Errorf("unexpected retired: %d", len(rts))
+	}
+	if rts := pool.retire(); len(rts) != 0 {
+		t.Errorf("unexpected retired again: %d", len(rts))
+	}
+	if _, err = pool.get(); err != errPoolRetired {
+		t.Errorf("unexpected err: %v", err)
+	}
+	// a runtime in use is thrown away as it's put
+	if pool.put(inUse) {
+		t.Error("unexpected put")
+	}
+	if stats := pool.stats(); stats.Size != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if err = pool.warm(); err != errPoolRetired {
+		t.Errorf("unexpected err: %v", err)
+	}
+	if atomic.LoadInt32(created) != 2 {
+		t.Errorf("unexpected created: %d", atomic.LoadInt32(created))
+	}
+}
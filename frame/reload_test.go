@@ -0,0 +1,234 @@
+package frame
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jumboframes/tigerbalm"
+	"github.com/jumboframes/tigerbalm/bus"
+	"github.com/kataras/iris/v12"
+)
+
+// httpSlot keeps handlers by method and path instead of serving them
+type httpSlot struct {
+	mu       sync.Mutex
+	app      *iris.Application
+	handlers map[string]bus.Handler
+}
+
+func newHttpSlot() *httpSlot {
+	return &httpSlot{
+		app:      iris.New(),
+		handlers: make(map[string]bus.Handler),
+	}
+}
+
+func (slot *httpSlot) AddHandler(handler bus.Handler, matches ...interface{}) {
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+	slot.handlers[matches[0].(string)+" "+matches[1].(string)] = handler
+}
+
+func (slot *httpSlot) DelHandler(matches ...interface{}) {
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+	delete(slot.handlers, matches[0].(string)+" "+matches[1].(string))
+}
+
+func (slot *httpSlot) Type() bus.SlotType {
+	return bus.SlotHttp
+}
+
+// serve returns the status and body of GET path, 404 if not registered
+func (slot *httpSlot) serve(path string) (int, string) {
+	slot.mu.Lock()
+	handler, ok := slot.handlers[http.MethodGet+" "+path]
+	slot.mu.Unlock()
+	if !ok {
+		return http.StatusNotFound, ""
+	}
+	rec := httptest.NewRecorder()
+	ctx := slot.app.ContextPool.Acquire(rec,
+		httptest.NewRequest(http.MethodGet, path, nil))
+	handler(&bus.ContextHttp{Context: ctx, RelativePath: path})
+	slot.app.ContextPool.Release(ctx)
+	return rec.Code, rec.Body.String()
+}
+
+// pluginSrc serves body on GET path, init and destroy log the body
+func pluginSrc(path, body string) string {
+	return `function register() {
+		return {"route": {"match": {"path": "` + path + `", "method": "GET"},
+			"handler": function() { return {"Body": "` + body + `"} }}}
+	}
+	function init() { require("log").Info("init ` + body + `") }
+	function destroy() { require("log").Info("destroy ` + body + `") }`
+}
+
+// newTestFrame loads plugins of a temporary dir, http routes go to slot
+func newTestFrame(t *testing.T, eng string, plugins map[string]string) (
+	*Frame, *httpSlot) {
+
+	conf := setConf(t, eng, 1)
+	conf.Plugin.Path = t.TempDir()
+	for name, src := range plugins {
+		writePlugin(t, name, src)
+	}
+	slot := newHttpSlot()
+	slotBus := bus.NewSlotBus()
+	slotBus.AddSlot(slot)
+	frame, err := NewFrame(slotBus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame, slot
+}
+
+func writePlugin(t *testing.T, name, src string) {
+	err := ioutil.WriteFile(filepath.Join(tigerbalm.Conf.Plugin.Path, name+ExtJS),
+		[]byte(src), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// pluginLog returns what the plugin logged so far
+func pluginLog(t *testing.T, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join(tigerbalm.Conf.Plugin.Log.Path,
+		name, name+ExtLog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestFrameReload(t *testing.T) {
+	for _, name := range engines {
+		frame, slot := newTestFrame(t, name, map[string]string{
+			"a": pluginSrc("/a", "v1"),
+		})
+		if status, body := slot.serve("/a"); status != http.StatusOK || body != "v1" {
+			t.Errorf("%s: unexpected rsp: %d %s", name, status, body)
+		}
+
+		// a failed version is rolled back, the old one keeps serving
+		for _, src := range []string{
+			`function register() {`,
+			pluginSrc("/a", "v2") + `
+			function init() { throw "init failed" }`,
+		} {
+			writePlugin(t, "a", src)
+			if err := frame.reloadPlugin("a" + ExtJS); err == nil {
+				t.Errorf("%s: unexpected reloaded", name)
+			}
+			if status, body := slot.serve("/a"); status != http.StatusOK || body != "v1" {
+				t.Errorf("%s: unexpected rsp after rollback: %d %s", name, status, body)
+			}
+		}
+
+		// routes gone are unregistered, the old version is destroyed
+		writePlugin(t, "a", pluginSrc("/b", "v2"))
+		if err := frame.reloadPlugin("a" + ExtJS); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+		if status, _ := slot.serve("/a"); status != http.StatusNotFound {
+			t.Errorf("%s: unexpected status of gone route: %d", name, status)
+		}
+		if status, body := slot.serve("/b"); status != http.StatusOK || body != "v2" {
+			t.Errorf("%s: unexpected rsp: %d %s", name, status, body)
+		}
+		log := pluginLog(t, "a")
+		if strings.Count(log, "destroy v1") != 1 || strings.Count(log, "init v2") != 1 {
+			t.Errorf("%s: unexpected hooks: %s", name, log)
+		}
+
+		if err := frame.unloadPlugin("a" + ExtJS); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+		if status, _ := slot.serve("/b"); status != http.StatusNotFound {
+			t.Errorf("%s: unexpected status after unloading: %d", name, status)
+		}
+		if log := pluginLog(t, "a"); strings.Count(log, "destroy v2") != 1 {
+			t.Errorf("%s: unexpected hooks after unloading: %s", name, log)
+		}
+		frame.Fini()
+	}
+}
+
+func TestFrameConflict(t *testing.T) {
+	for _, name := range engines {
+		frame, slot := newTestFrame(t, name, map[string]string{
+			"a": pluginSrc("/a", "a"),
+		})
+
+		// a route owned by another plugin rejects the loading
+		writePlugin(t, "b", pluginSrc("/a", "b"))
+		if err := frame.loadPlugin("b" + ExtJS); !errors.Is(err, tigerbalm.ErrConflict) {
+			t.Errorf("%s: unexpected err: %v", name, err)
+		}
+		conflicts := frame.Conflicts()
+		expected := Conflict{Kind: ConflictHttp, Match: "GET /a", Plugin: "b", Owner: "a"}
+		if len(conflicts) != 1 || conflicts[0] != expected {
+			t.Errorf("%s: unexpected conflicts: %+v", name, conflicts)
+		}
+		if status, body := slot.serve("/a"); status != http.StatusOK || body != "a" {
+			t.Errorf("%s: unexpected rsp: %d %s", name, status, body)
+		}
+		// the rejected plugin never ran its hooks
+		if log := pluginLog(t, "b"); strings.Contains(log, "init b") {
+			t.Errorf("%s: unexpected hooks: %s", name, log)
+		}
+
+		// fixed, the conflict is cleared
+		writePlugin(t, "b", pluginSrc("/b", "b"))
+		if err := frame.reloadPlugin("b" + ExtJS); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+		if conflicts = frame.Conflicts(); len(conflicts) != 0 {
+			t.Errorf("%s: unexpected conflicts: %+v", name, conflicts)
+		}
+		if status, body := slot.serve("/b"); status != http.StatusOK || body != "b" {
+			t.Errorf("%s: unexpected rsp: %d %s", name, status, body)
+		}
+		frame.Fini()
+	}
+}
+
+func TestFrameHttpStatus(t *testing.T) {
+	frame, slot := newTestFrame(t, "goja", map[string]string{
+		"a": `function register() {
+			return {"timeout": 50, "route": {"match": {"path": "/a", "method": "GET"},
+				"handler": function() { while (true) {} }}}
+		}`,
+	})
+	defer frame.Fini()
+	if status, _ := slot.serve("/a"); status != http.StatusGatewayTimeout {
+		t.Errorf("unexpected status of timeout: %d", status)
+	}
+
+	// the only runtime is in use
+	tigerbalm.Conf.Plugin.Pool.MaxSize = 1
+	tigerbalm.Conf.Plugin.Pool.WaitTimeout = 1
+	writePlugin(t, "a", pluginSrc("/a", "a"))
+	if err := frame.reloadPlugin("a" + ExtJS); err != nil {
+		t.Fatal(err)
+	}
+	plugin := frame.namePlugins["a"]
+	pool, rt, err := plugin.getRuntime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := slot.serve("/a"); status != http.StatusServiceUnavailable {
+		t.Errorf("unexpected status of exhausted pool: %d", status)
+	}
+	plugin.putRuntime(pool, rt)
+	if status, body := slot.serve("/a"); status != http.StatusOK || body != "a" {
+		t.Errorf("unexpected rsp: %d %s", status, body)
+	}
+}
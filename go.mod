@@ -4,14 +4,19 @@ go 1.15
 
 require (
 	github.com/Shopify/sarama v1.29.0
-	github.com/fsnotify/fsnotify v1.4.7
+	github.com/alicebob/miniredis/v2 v2.23.0
+	github.com/dop251/goja v0.0.0-20221118162653-d4bf6fde1b86
+	github.com/fsnotify/fsnotify v1.4.9
+	github.com/go-redis/redis/v8 v8.11.5
 	github.com/jstemmer/gotags v1.4.1 // indirect
 	github.com/kataras/iris v0.0.2
 	github.com/kataras/iris/v12 v12.1.8
 	github.com/lestrrat-go/file-rotatelogs v2.4.0+incompatible
 	github.com/lestrrat-go/strftime v1.0.5 // indirect
 	github.com/robertkrimen/otto v0.0.0-20211024170158-b87d35c0b86f
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/shurcooL/sanitized_anchor_name v1.0.0 // indirect
+	github.com/xdg-go/scram v1.0.2
 	google.golang.org/appengine v1.6.7
-	gopkg.in/yaml.v2 v2.2.8
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,60 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/jumboframes/tigerbalm"
+	"github.com/jumboframes/tigerbalm/frame/capal/tblog"
+)
+
+// Admin serves states of tigerbalm as json, apart from the web of plugins
+type Admin struct {
+	mux    *http.ServeMux
+	server *http.Server
+	l      net.Listener
+}
+
+func NewAdmin() (*Admin, error) {
+	l, err := net.Listen("tcp", tigerbalm.Conf.Admin.Addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	return &Admin{mux, &http.Server{Handler: mux}, l}, nil
+}
+
+// Handle serves what state returns at path
+func (admin *Admin) Handle(path string, state func() interface{}) {
+	admin.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		data, err := json.Marshal(state())
+		if err != nil {
+			tblog.Errorf("admin::handle | path: %s, marshal err: %s", path, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write(data)
+	})
+}
+
+func (admin *Admin) Serve(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		admin.server.Shutdown(context.TODO())
+	}()
+
+	if err := admin.server.Serve(admin.l); err != nil {
+		if err == http.ErrServerClosed {
+			tblog.Info("admin::server | server quit")
+		} else {
+			tblog.Errorf("admin::server | server quit: %s", err)
+		}
+	}
+}
+
+func (admin *Admin) Fini() {
+	admin.l.Close()
+}
@@ -15,8 +15,13 @@ type Consumer struct {
 }
 
 func NewConsumer() (*Consumer, error) {
+	options, err := tbkafka.ConsumerGroupOptionsFromConf()
+	if err != nil {
+		tblog.Errorf("newconsumer | consumer group options err: %s", err)
+		return nil, err
+	}
 	failedCh := make(chan *tbkafka.ConsumerGroupMessage)
-	cg, err := tbkafka.NewConsumerGroup(tigerbalm.Conf.Kafka.Brokers,
+	options = append(options,
 		tbkafka.OptionConsumerGroupFailedCh(failedCh),
 		tbkafka.OptionConsumerGroupHeartbeatInterval(
 			tigerbalm.Conf.Kafka.Consumer.Group.Heartbeat.Interval*time.Second),
@@ -24,6 +29,7 @@ func NewConsumer() (*Consumer, error) {
 			tigerbalm.Conf.Kafka.Consumer.Offsets.Initial),
 		tbkafka.OptionConsumerGroupSessionTimeout(
 			tigerbalm.Conf.Kafka.Consumer.Group.Session.Timeout*time.Second))
+	cg, err := tbkafka.NewConsumerGroup(tigerbalm.Conf.Kafka.Brokers, options...)
 	if err != nil {
 		tblog.Errorf("newconsumer | new consumer group err: %s", err)
 		return nil, err
@@ -44,8 +50,11 @@ func (consumer *Consumer) handleFailed() {
 	}
 }
 
+// matches are topic and group, optionally followed by batch size, window and
+// concurrency, a batch handler gets *tbkafka.ConsumerGroupBatch. Adding to a
+// topic and group consuming swaps the handler.
 func (consumer *Consumer) AddHandler(handler bus.Handler, matches ...interface{}) {
-	if len(matches) != 2 {
+	if len(matches) != 2 && len(matches) != 5 {
 		return
 	}
 	topic, ok := matches[0].(string)
@@ -58,9 +67,47 @@ func (consumer *Consumer) AddHandler(handler bus.Handler, matches ...interface{}
 		tblog.Error("consumer::addhandler | matches 1 not string")
 		return
 	}
-	err := consumer.cg.Add(topic, group, func(msg *tbkafka.ConsumerGroupMessage) {
-		handler(msg)
-	})
+	size, window, concurrency := 0, time.Duration(0), 0
+	if len(matches) == 5 {
+		size, ok = matches[2].(int)
+		if !ok {
+			tblog.Error("consumer::addhandler | matches 2 not int")
+			return
+		}
+		window, ok = matches[3].(time.Duration)
+		if !ok {
+			tblog.Error("consumer::addhandler | matches 3 not duration")
+			return
+		}
+		concurrency, ok = matches[4].(int)
+		if !ok {
+			tblog.Error("consumer::addhandler | matches 4 not int")
+			return
+		}
+	}
+	cgHandler := &tbkafka.ConsumerGroupHandler{
+		Window:      window,
+		Concurrency: concurrency,
+	}
+	if size > 0 {
+		cgHandler.Batch = size
+		cgHandler.BatchHandler = func(batch *tbkafka.ConsumerGroupBatch) {
+			handler(batch)
+		}
+	} else {
+		cgHandler.Handlers = []func(*tbkafka.ConsumerGroupMessage){
+			func(msg *tbkafka.ConsumerGroupMessage) {
+				handler(msg)
+			},
+		}
+	}
+	// a running group keeps its membership
+	if consumer.cg.Swap(topic, group, cgHandler) == nil {
+		tblog.Debugf("consumer::addhandler | swap success, topic: %s, group: %s",
+			topic, group)
+		return
+	}
+	err := consumer.cg.AddHandler(topic, group, cgHandler)
 	if err != nil {
 		tblog.Errorf("consumer::addhandler | add err: %s", err)
 		return
@@ -70,7 +117,7 @@ func (consumer *Consumer) AddHandler(handler bus.Handler, matches ...interface{}
 }
 
 func (consumer *Consumer) DelHandler(matches ...interface{}) {
-	if len(matches) != 2 {
+	if len(matches) != 2 && len(matches) != 5 {
 		return
 	}
 	topic, ok := matches[0].(string)
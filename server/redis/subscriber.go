@@ -0,0 +1,70 @@
+package redis
+
+import (
+	"github.com/jumboframes/tigerbalm/bus"
+	"github.com/jumboframes/tigerbalm/frame/capal/tblog"
+	"github.com/jumboframes/tigerbalm/frame/capal/tbredis"
+)
+
+type Subscriber struct {
+	sub *tbredis.Subscriber
+}
+
+func NewSubscriber(r *tbredis.Redis) *Subscriber {
+	return &Subscriber{tbredis.NewSubscriber(r)}
+}
+
+func (subscriber *Subscriber) Fini() {
+	subscriber.sub.Fini()
+}
+
+// matches are kind, key and group, group is for streams only
+func (subscriber *Subscriber) AddHandler(handler bus.Handler, matches ...interface{}) {
+	kind, key, group, ok := parseMatches("addhandler", matches...)
+	if !ok {
+		return
+	}
+	err := subscriber.sub.Add(kind, key, group, func(msg *tbredis.Message) {
+		handler(msg)
+	})
+	if err != nil {
+		tblog.Errorf("subscriber::addhandler | add err: %s", err)
+		return
+	}
+	tblog.Debugf("subscriber::addhandler | add success, kind: %s, key: %s, group: %s",
+		kind, key, group)
+}
+
+func (subscriber *Subscriber) DelHandler(matches ...interface{}) {
+	kind, key, group, ok := parseMatches("delhandler", matches...)
+	if !ok {
+		return
+	}
+	err := subscriber.sub.Del(kind, key, group)
+	if err != nil {
+		tblog.Errorf("subscriber::delhandler | del err: %s", err)
+		return
+	}
+	tblog.Debugf("subscriber::delhandler | del success, kind: %s, key: %s, group: %s",
+		kind, key, group)
+}
+
+func (subscriber *Subscriber) Type() bus.SlotType {
+	return bus.SlotRedis
+}
+
+func parseMatches(fn string, matches ...interface{}) (string, string, string, bool) {
+	if len(matches) != 3 {
+		return "", "", "", false
+	}
+	strs := make([]string, 3)
+	for index, match := range matches {
+		str, ok := match.(string)
+		if !ok {
+			tblog.Errorf("subscriber::%s | matches[%d] not string", fn, index)
+			return "", "", "", false
+		}
+		strs[index] = str
+	}
+	return strs[0], strs[1], strs[2], true
+}
@@ -0,0 +1,236 @@
+package timer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jumboframes/tigerbalm/bus"
+	"github.com/jumboframes/tigerbalm/frame/capal/tblog"
+	"github.com/robfig/cron/v3"
+)
+
+type Schedule struct {
+	Plugin  string
+	Spec    string // cron expression or @every interval
+	Overlap string
+	Last    time.Time // zero if never run
+	Next    time.Time
+	Running bool
+	Skipped int // runs skipped for overlapping
+}
+
+type Timer struct {
+	mu      sync.RWMutex
+	cron    *cron.Cron
+	entries map[string]*entry
+}
+
+func NewTimer() *Timer {
+	timer := &Timer{
+		cron:    cron.New(),
+		entries: make(map[string]*entry),
+	}
+	timer.cron.Start()
+	return timer
+}
+
+func (timer *Timer) Fini() {
+	// wait for running jobs
+	<-timer.cron.Stop().Done()
+}
+
+// matches are plugin name, cron expression, interval and overlap,
+// one of cron and interval is set.
+func (timer *Timer) AddHandler(handler bus.Handler, matches ...interface{}) {
+	plugin, spec, schedule, overlap, ok := parseMatches("addhandler", matches...)
+	if !ok {
+		return
+	}
+	key := entryKey(plugin, spec, overlap)
+
+	timer.mu.Lock()
+	defer timer.mu.Unlock()
+
+	if _, ok := timer.entries[key]; ok {
+		tblog.Errorf("timer::addhandler | plugin: %s, schedule: %s existed",
+			plugin, spec)
+		return
+	}
+	entry := &entry{
+		plugin:  plugin,
+		spec:    spec,
+		overlap: overlap,
+		handler: handler,
+		timer:   timer,
+		running: make(chan struct{}, 1),
+		pending: make(chan struct{}, 1),
+	}
+	entry.id = timer.cron.Schedule(schedule, entry)
+	timer.entries[key] = entry
+	tblog.Debugf("timer::addhandler | add success, plugin: %s, schedule: %s, next: %s",
+		plugin, spec, timer.cron.Entry(entry.id).Next)
+}
+
+func (timer *Timer) DelHandler(matches ...interface{}) {
+	plugin, spec, _, overlap, ok := parseMatches("delhandler", matches...)
+	if !ok {
+		return
+	}
+	key := entryKey(plugin, spec, overlap)
+
+	timer.mu.Lock()
+	defer timer.mu.Unlock()
+
+	entry, ok := timer.entries[key]
+	if !ok {
+		tblog.Errorf("timer::delhandler | plugin: %s, schedule: %s not found",
+			plugin, spec)
+		return
+	}
+	timer.cron.Remove(entry.id)
+	delete(timer.entries, key)
+	tblog.Debugf("timer::delhandler | del success, plugin: %s, schedule: %s",
+		plugin, spec)
+}
+
+func (timer *Timer) Type() bus.SlotType {
+	return bus.SlotTimer
+}
+
+// Schedules returns all schedules with their last and next run times
+func (timer *Timer) Schedules() []Schedule {
+	timer.mu.RLock()
+	defer timer.mu.RUnlock()
+
+	schedules := make([]Schedule, 0, len(timer.entries))
+	for _, entry := range timer.entries {
+		last, skipped := entry.stats()
+		schedules = append(schedules, Schedule{
+			Plugin:  entry.plugin,
+			Spec:    entry.spec,
+			Overlap: entry.overlap,
+			Last:    last,
+			Next:    timer.cron.Entry(entry.id).Next,
+			Running: len(entry.running) != 0,
+			Skipped: skipped,
+		})
+	}
+	return schedules
+}
+
+// a schedule changing only the overlap is another entry
+func entryKey(plugin, spec, overlap string) string {
+	return plugin + "|" + spec + "|" + overlap
+}
+
+func parseMatches(fn string, matches ...interface{}) (
+	string, string, cron.Schedule, string, bool) {
+
+	if len(matches) != 4 {
+		return "", "", nil, "", false
+	}
+	plugin, ok := matches[0].(string)
+	if !ok {
+		tblog.Errorf("timer::%s | matches[0] not string", fn)
+		return "", "", nil, "", false
+	}
+	expr, ok := matches[1].(string)
+	if !ok {
+		tblog.Errorf("timer::%s | matches[1] not string", fn)
+		return "", "", nil, "", false
+	}
+	interval, ok := matches[2].(time.Duration)
+	if !ok {
+		tblog.Errorf("timer::%s | matches[2] not duration", fn)
+		return "", "", nil, "", false
+	}
+	overlap, ok := matches[3].(string)
+	if !ok {
+		tblog.Errorf("timer::%s | matches[3] not string", fn)
+		return "", "", nil, "", false
+	}
+	if overlap == "" {
+		overlap = bus.OverlapSkip
+	}
+	if expr != "" {
+		schedule, err := cron.ParseStandard(expr)
+		if err != nil {
+			tblog.Errorf("timer::%s | parse cron: %s err: %s", fn, expr, err)
+			return "", "", nil, "", false
+		}
+		return plugin, expr, schedule, overlap, true
+	}
+	if interval <= 0 {
+		tblog.Errorf("timer::%s | neither cron nor interval set", fn)
+		return "", "", nil, "", false
+	}
+	// intervals are rounded to seconds, at least one second
+	schedule := cron.Every(interval)
+	return plugin, "@every " + schedule.Delay.String(), schedule, overlap, true
+}
+
+type entry struct {
+	id      cron.EntryID
+	plugin  string
+	spec    string
+	overlap string
+	handler bus.Handler
+	timer   *Timer
+
+	// one token for the run in progress, one for the run queued
+	running chan struct{}
+	pending chan struct{}
+
+	mu      sync.Mutex
+	last    time.Time
+	skipped int
+}
+
+func (entry *entry) Run() {
+	select {
+	case entry.running <- struct{}{}:
+	default:
+		// queue one run at most, runs beyond are skipped
+		if entry.overlap != bus.OverlapQueue || !entry.queue() {
+			entry.mu.Lock()
+			entry.skipped++
+			entry.mu.Unlock()
+			tblog.Warnf("timer::run | plugin: %s, schedule: %s still running, skipped",
+				entry.plugin, entry.spec)
+			return
+		}
+	}
+	defer func() {
+		<-entry.running
+	}()
+
+	now := time.Now()
+	entry.mu.Lock()
+	last := entry.last
+	entry.last = now
+	entry.mu.Unlock()
+
+	entry.handler(&bus.ContextTimer{
+		Time: now,
+		Last: last,
+		Next: entry.timer.cron.Entry(entry.id).Next,
+	})
+}
+
+// queue waits for the run in progress, false if a run is queued already
+func (entry *entry) queue() bool {
+	select {
+	case entry.pending <- struct{}{}:
+	default:
+		return false
+	}
+	entry.running <- struct{}{}
+	<-entry.pending
+	return true
+}
+
+func (entry *entry) stats() (time.Time, int) {
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	return entry.last, entry.skipped
+}
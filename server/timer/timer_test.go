@@ -0,0 +1,119 @@
+package timer
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jumboframes/tigerbalm/bus"
+)
+
+// addEntry adds a schedule never fired in tests, runs block until release
+// is closed
+func addEntry(t *testing.T, timer *Timer, overlap string,
+	release <-chan struct{}) (*entry, *int32) {
+
+	runs := new(int32)
+	timer.AddHandler(func(interface{}) {
+		atomic.AddInt32(runs, 1)
+		<-release
+	}, "test", "", time.Hour, overlap)
+	entry, ok := timer.entries[entryKey("test", "@every 1h0m0s", overlap)]
+	if !ok {
+		t.Fatalf("%s: entry not added", overlap)
+	}
+	return entry, runs
+}
+
+// waitRuns waits until runs reach n
+func waitRuns(t *testing.T, runs *int32, n int32) {
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(runs) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected runs: %d", atomic.LoadInt32(runs))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTimerOverlap(t *testing.T) {
+	tests := []struct {
+		overlap string
+		runs    int32
+		skipped int
+	}{{
+		overlap: bus.OverlapSkip,
+		runs:    1,
+		skipped: 2,
+	}, {
+		// one run queued, the others skipped
+		overlap: bus.OverlapQueue,
+		runs:    2,
+		skipped: 1,
+	}}
+	for _, test := range tests {
+		timer := NewTimer()
+		release := make(chan struct{})
+		entry, runs := addEntry(t, timer, test.overlap, release)
+
+		done := make(chan struct{}, 3)
+		run := func() {
+			entry.Run()
+			done <- struct{}{}
+		}
+		go run()
+		waitRuns(t, runs, 1)
+		go run()
+		go run()
+		// the overlapping runs are either skipped or queued
+		deadline := time.Now().Add(time.Second)
+		for {
+			_, skipped := entry.stats()
+			if skipped == test.skipped {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: unexpected skipped: %d", test.overlap, skipped)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		close(release)
+		for i := 0; i < 3; i++ {
+			<-done
+		}
+		if got := atomic.LoadInt32(runs); got != test.runs {
+			t.Errorf("%s: unexpected runs: %d", test.overlap, got)
+		}
+		schedules := timer.Schedules()
+		if len(schedules) != 1 || schedules[0].Skipped != test.skipped ||
+			schedules[0].Running || schedules[0].Last.IsZero() {
+			t.Errorf("%s: unexpected schedules: %+v", test.overlap, schedules)
+		}
+		timer.Fini()
+	}
+}
+
+func TestTimerAddHandler(t *testing.T) {
+	timer := NewTimer()
+	defer timer.Fini()
+
+	handler := func(interface{}) {}
+	timer.AddHandler(handler, "test", "bad cron", time.Duration(0), bus.OverlapSkip)
+	timer.AddHandler(handler, "test", "", time.Duration(0), bus.OverlapSkip)
+	if len(timer.entries) != 0 {
+		t.Errorf("unexpected entries: %d", len(timer.entries))
+	}
+
+	// a schedule changing only the overlap is another entry
+	timer.AddHandler(handler, "test", "*/5 * * * *", time.Duration(0), bus.OverlapSkip)
+	timer.AddHandler(handler, "test", "*/5 * * * *", time.Duration(0), bus.OverlapSkip)
+	timer.AddHandler(handler, "test", "*/5 * * * *", time.Duration(0), bus.OverlapQueue)
+	if len(timer.entries) != 2 {
+		t.Errorf("unexpected entries: %d", len(timer.entries))
+	}
+	timer.DelHandler("test", "*/5 * * * *", time.Duration(0), bus.OverlapQueue)
+	if schedules := timer.Schedules(); len(schedules) != 1 ||
+		schedules[0].Overlap != bus.OverlapSkip {
+		t.Errorf("unexpected schedules: %+v", schedules)
+	}
+}